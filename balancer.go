@@ -0,0 +1,384 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/naming"
+	"google.golang.org/grpc/status"
+)
+
+var errBalancerClosed = errors.New("grpc: balancer is closed")
+
+// Metadata is attached to the updates returned from the ConsulWatcher when
+// weighted load balancing is enabled or an endpoint is draining
+type Metadata struct {
+	Weight int
+	// Added is the time the endpoint was first resolved, it is only set when
+	// the resolver is used with the SlowStart balancer
+	Added time.Time
+	// Draining endpoints receive no new requests and are removed once their
+	// requests have completed
+	Draining bool
+}
+
+// WeightedRoundRobin returns a Balancer that selects addresses using a smooth
+// weighted round robin, the weight for each address is read from the Metadata
+// returned by the resolver. Addresses without Metadata have a weight of 1.
+// example usage:
+// r := resolver.NewServiceQueryResolver("http://consulAddr:8500")
+// r.Weighted = true
+// lb := resolver.WeightedRoundRobin(r)
+func WeightedRoundRobin(r naming.Resolver) grpc.Balancer {
+	return &weightedRoundRobin{r: r}
+}
+
+type weightedAddr struct {
+	addr      grpc.Address
+	weight    int
+	current   float64
+	connected bool
+	tags      []string
+	draining  bool
+	inflight  int // requests which have not completed
+	added     time.Time
+}
+
+type weightedRoundRobin struct {
+	r      naming.Resolver
+	w      naming.Watcher
+	addrs  []*weightedAddr
+	mu     sync.Mutex
+	addrCh chan []grpc.Address
+	waitCh chan struct{}
+	done   bool
+
+	// routes and tags are set by the TagRouter
+	routes []TagRoute
+	tags   func(w naming.Watcher, addr string) []string
+
+	// slowStart is set by the SlowStart balancer
+	slowStart *SlowStartConfig
+	now       func() time.Time
+}
+
+func (wr *weightedRoundRobin) watchAddrUpdates() error {
+	updates, err := wr.w.Next()
+	if err != nil {
+		grpclog.Warningf("grpc: the naming watcher stops working due to %v.", err)
+		return err
+	}
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	// an endpoint which is replaced with a delete and an add keeps its
+	// connected state as the address notified to gRPC does not change
+	removed := make(map[grpc.Address]*weightedAddr)
+
+	for _, update := range updates {
+		// the address is notified to gRPC without metadata so that a change in
+		// weight does not cause the connection to be recreated
+		addr := grpc.Address{Addr: update.Addr}
+
+		switch update.Op {
+		case naming.Add:
+			weight := 1
+			draining := false
+			var added time.Time
+			if m, ok := update.Metadata.(Metadata); ok {
+				weight = m.Weight
+				draining = m.Draining
+				added = m.Added
+			}
+
+			a := wr.find(addr)
+			if a == nil {
+				a = removed[addr]
+				if a == nil {
+					a = &weightedAddr{addr: addr}
+				}
+
+				delete(removed, addr)
+				wr.addrs = append(wr.addrs, a)
+			}
+
+			a.weight = weight
+			a.draining = draining
+			a.added = added
+			if wr.tags != nil {
+				a.tags = wr.tags(wr.w, update.Addr)
+			}
+
+			// a draining address without requests is already idle
+			if a.draining && a.inflight == 0 {
+				wr.remove(a)
+			}
+		case naming.Delete:
+			if a := wr.find(addr); a != nil {
+				removed[addr] = a
+				wr.remove(a)
+			}
+		default:
+			grpclog.Errorln("Unknown update.Op ", update.Op)
+		}
+	}
+
+	if wr.done {
+		return grpc.ErrClientConnClosing
+	}
+
+	wr.notify()
+
+	return nil
+}
+
+// notify sends the open addresses to gRPC, a pending notification which has
+// not been read is replaced, the caller must hold the mutex
+func (wr *weightedRoundRobin) notify() {
+	open := make([]grpc.Address, len(wr.addrs))
+	for i, a := range wr.addrs {
+		open[i] = a.addr
+	}
+
+	select {
+	case <-wr.addrCh:
+	default:
+	}
+	wr.addrCh <- open
+}
+
+// remove removes the address so that gRPC closes its connection, the caller
+// must hold the mutex
+func (wr *weightedRoundRobin) remove(addr *weightedAddr) {
+	for i, a := range wr.addrs {
+		if a == addr {
+			copy(wr.addrs[i:], wr.addrs[i+1:])
+			wr.addrs = wr.addrs[:len(wr.addrs)-1]
+			return
+		}
+	}
+}
+
+// release returns the function called by gRPC when a request to the address
+// completes, a draining address is removed once its last request completes
+func (wr *weightedRoundRobin) release(a *weightedAddr) func() {
+	return func() {
+		wr.mu.Lock()
+		defer wr.mu.Unlock()
+
+		a.inflight--
+		if !a.draining || a.inflight > 0 || wr.find(a.addr) != a {
+			return
+		}
+
+		wr.remove(a)
+		if !wr.done && wr.addrCh != nil {
+			wr.notify()
+		}
+	}
+}
+
+func (wr *weightedRoundRobin) find(addr grpc.Address) *weightedAddr {
+	for _, a := range wr.addrs {
+		if a.addr == addr {
+			return a
+		}
+	}
+
+	return nil
+}
+
+// next returns the next address for the request, when the balancer has
+// routes the address is selected from the group of addresses for the route
+func (wr *weightedRoundRobin) next(ctx context.Context) *weightedAddr {
+	if len(wr.routes) == 0 {
+		return wr.pick(nil)
+	}
+
+	if tag := wr.routeTag(ctx); tag != "" {
+		if a := wr.pick(func(a *weightedAddr) bool { return hasTag(a.tags, tag) }); a != nil {
+			return a
+		}
+	}
+
+	// requests which do not match a route, or whose group has no connected
+	// addresses, are sent to the addresses which do not belong to a route
+	if a := wr.pick(wr.isDefault); a != nil {
+		return a
+	}
+
+	return wr.pick(nil)
+}
+
+// pick returns the connected address with the highest current weight from
+// the addresses matching the filter, the selected address has its current
+// weight reduced by the total so that over a full cycle each address is
+// selected in proportion to its weight
+func (wr *weightedRoundRobin) pick(filter func(a *weightedAddr) bool) *weightedAddr {
+	var best *weightedAddr
+	total := 0.0
+
+	for _, a := range wr.addrs {
+		if !a.connected || a.draining || a.weight <= 0 {
+			continue
+		}
+
+		if filter != nil && !filter(a) {
+			continue
+		}
+
+		weight := wr.effectiveWeight(a)
+		a.current += weight
+		total += weight
+
+		if best == nil || a.current > best.current {
+			best = a
+		}
+	}
+
+	if best != nil {
+		best.current -= total
+	}
+
+	return best
+}
+
+// Start resolves the target and begins watching for updates
+func (wr *weightedRoundRobin) Start(target string, config grpc.BalancerConfig) error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if wr.done {
+		return grpc.ErrClientConnClosing
+	}
+
+	if wr.r == nil {
+		wr.addrs = append(wr.addrs, &weightedAddr{addr: grpc.Address{Addr: target}, weight: 1})
+		return nil
+	}
+
+	w, err := wr.r.Resolve(target)
+	if err != nil {
+		return err
+	}
+
+	wr.w = w
+	wr.addrCh = make(chan []grpc.Address, 1)
+
+	go func() {
+		for {
+			if err := wr.watchAddrUpdates(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Up sets the connected state of addr and notifies any pending Get calls
+func (wr *weightedRoundRobin) Up(addr grpc.Address) func(error) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	a := wr.find(addr)
+	if a == nil || a.connected {
+		return nil
+	}
+	a.connected = true
+
+	if wr.waitCh != nil {
+		close(wr.waitCh)
+		wr.waitCh = nil
+	}
+
+	return func(err error) {
+		wr.down(addr)
+	}
+}
+
+func (wr *weightedRoundRobin) down(addr grpc.Address) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if a := wr.find(addr); a != nil {
+		a.connected = false
+	}
+}
+
+// Get returns the next address based on the weights of the connected addresses
+func (wr *weightedRoundRobin) Get(ctx context.Context, opts grpc.BalancerGetOptions) (addr grpc.Address, put func(), err error) {
+	for {
+		wr.mu.Lock()
+		if wr.done {
+			wr.mu.Unlock()
+			return addr, nil, grpc.ErrClientConnClosing
+		}
+
+		if a := wr.next(ctx); a != nil {
+			a.inflight++
+			wr.mu.Unlock()
+			return a.addr, wr.release(a), nil
+		}
+
+		if !opts.BlockingWait {
+			defer wr.mu.Unlock()
+
+			if len(wr.addrs) == 0 {
+				return addr, nil, status.Errorf(codes.Unavailable, "there is no address available")
+			}
+
+			// return an address which is not connected, gRPC will fail the RPC
+			return wr.addrs[0].addr, nil, nil
+		}
+
+		if wr.waitCh == nil {
+			wr.waitCh = make(chan struct{})
+		}
+		ch := wr.waitCh
+		wr.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return addr, nil, ctx.Err()
+		case <-ch:
+		}
+	}
+}
+
+// Notify returns the channel used to notify gRPC of the addresses to connect
+func (wr *weightedRoundRobin) Notify() <-chan []grpc.Address {
+	return wr.addrCh
+}
+
+// Close closes the balancer and the watcher
+func (wr *weightedRoundRobin) Close() error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if wr.done {
+		return errBalancerClosed
+	}
+
+	wr.done = true
+	if wr.w != nil {
+		wr.w.Close()
+	}
+
+	if wr.waitCh != nil {
+		close(wr.waitCh)
+		wr.waitCh = nil
+	}
+
+	if wr.addrCh != nil {
+		close(wr.addrCh)
+	}
+
+	return nil
+}
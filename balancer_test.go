@@ -0,0 +1,129 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/naming"
+)
+
+type testWatcher struct {
+	updates chan []*naming.Update
+}
+
+func (w *testWatcher) Next() ([]*naming.Update, error) {
+	return <-w.updates, nil
+}
+
+func (w *testWatcher) Close() {}
+
+type testResolver struct {
+	w *testWatcher
+}
+
+func (r *testResolver) Resolve(target string) (naming.Watcher, error) {
+	return r.w, nil
+}
+
+func setupBalancer(t *testing.T, updates []*naming.Update) grpc.Balancer {
+	w := &testWatcher{make(chan []*naming.Update, 1)}
+	w.updates <- updates
+
+	b := WeightedRoundRobin(&testResolver{w})
+	err := b.Start("test", grpc.BalancerConfig{})
+	assert.NoError(t, err)
+
+	// wait for gRPC to be notified of the addresses and connect them
+	for _, a := range <-b.Notify() {
+		b.Up(a)
+	}
+
+	return b
+}
+
+func TestWeightedRoundRobinReturnsAddressesInProportionToWeight(t *testing.T) {
+	b := setupBalancer(t, []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080", Metadata: Metadata{Weight: 3}},
+		&naming.Update{Op: naming.Add, Addr: "localhost:8081", Metadata: Metadata{Weight: 1}},
+	})
+	defer b.Close()
+
+	calls := map[string]int{}
+	for i := 0; i < 8; i++ {
+		a, _, err := b.Get(context.Background(), grpc.BalancerGetOptions{})
+		assert.NoError(t, err)
+		calls[a.Addr]++
+	}
+
+	assert.Equal(t, 6, calls["localhost:8080"])
+	assert.Equal(t, 2, calls["localhost:8081"])
+}
+
+func TestWeightedRoundRobinUsesDefaultWeightWithoutMetadata(t *testing.T) {
+	b := setupBalancer(t, []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080"},
+		&naming.Update{Op: naming.Add, Addr: "localhost:8081"},
+	})
+	defer b.Close()
+
+	calls := map[string]int{}
+	for i := 0; i < 4; i++ {
+		a, _, _ := b.Get(context.Background(), grpc.BalancerGetOptions{})
+		calls[a.Addr]++
+	}
+
+	assert.Equal(t, 2, calls["localhost:8080"])
+	assert.Equal(t, 2, calls["localhost:8081"])
+}
+
+func TestWeightedRoundRobinNotifiesAddressesWithoutMetadata(t *testing.T) {
+	w := &testWatcher{make(chan []*naming.Update, 1)}
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080", Metadata: Metadata{Weight: 3}},
+	}
+
+	b := WeightedRoundRobin(&testResolver{w})
+	b.Start("test", grpc.BalancerConfig{})
+	defer b.Close()
+
+	addrs := <-b.Notify()
+
+	assert.Equal(t, []grpc.Address{grpc.Address{Addr: "localhost:8080"}}, addrs)
+}
+
+func TestWeightedRoundRobinReturnsErrorWhenNoAddresses(t *testing.T) {
+	b := setupBalancer(t, []*naming.Update{})
+	defer b.Close()
+
+	_, _, err := b.Get(context.Background(), grpc.BalancerGetOptions{})
+
+	assert.Error(t, err)
+}
+
+func TestWeightedRoundRobinKeepsConnectedStateWhenAddressReplaced(t *testing.T) {
+	w := &testWatcher{make(chan []*naming.Update, 1)}
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080", Metadata: Metadata{Weight: 3}},
+	}
+
+	b := WeightedRoundRobin(&testResolver{w})
+	b.Start("test", grpc.BalancerConfig{})
+	defer b.Close()
+
+	for _, a := range <-b.Notify() {
+		b.Up(a)
+	}
+
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Delete, Addr: "localhost:8080", Metadata: Metadata{Weight: 3}},
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080", Metadata: Metadata{Weight: 1}},
+	}
+	<-b.Notify()
+
+	a, _, err := b.Get(context.Background(), grpc.BalancerGetOptions{BlockingWait: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:8080", a.Addr)
+}
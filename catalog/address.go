@@ -0,0 +1,109 @@
+package catalog
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// AddressPolicy selects which of the addresses registered in Consul is
+// returned for an instance
+type AddressPolicy string
+
+const (
+	// AddressDefault uses the service address, falling back to the address
+	// of the node when the service does not have an address
+	AddressDefault AddressPolicy = ""
+	// AddressLAN uses the lan tagged address of the node
+	AddressLAN AddressPolicy = "lan"
+	// AddressWAN uses the wan tagged address of the node, this is used to
+	// reach instances in another datacenter
+	AddressWAN AddressPolicy = "wan"
+	// AddressLANIPv4 uses the lan_ipv4 tagged address of the node
+	AddressLANIPv4 AddressPolicy = "lan_ipv4"
+	// AddressLANIPv6 uses the lan_ipv6 tagged address of the node
+	AddressLANIPv6 AddressPolicy = "lan_ipv6"
+	// AddressWANIPv4 uses the wan_ipv4 tagged address of the node
+	AddressWANIPv4 AddressPolicy = "wan_ipv4"
+	// AddressWANIPv6 uses the wan_ipv6 tagged address of the node
+	AddressWANIPv6 AddressPolicy = "wan_ipv6"
+	// AddressPreferIPv6 uses an IPv6 address for dual stack nodes, the
+	// lan_ipv6 tagged address is used when set, then the default address when
+	// it is IPv6 and then the lan_ipv4 tagged address
+	AddressPreferIPv6 AddressPolicy = "prefer_ipv6"
+)
+
+// Addressing configures the address returned for each instance, when the
+// node does not have the tagged address for the policy the default address
+// is used. The api.AgentService of the Consul version used by this package
+// does not contain tagged addresses so the policy is applied to the tagged
+// addresses of the node.
+type Addressing struct {
+	// AddressPolicy selects the address for all targets, defaults to
+	// AddressDefault
+	AddressPolicy AddressPolicy
+	// TargetAddressPolicy overrides AddressPolicy for the given targets
+	TargetAddressPolicy map[string]AddressPolicy
+}
+
+// policy returns the AddressPolicy for the target
+func (a *Addressing) policy(name string) AddressPolicy {
+	if p, ok := a.TargetAddressPolicy[name]; ok {
+		return p
+	}
+
+	return a.AddressPolicy
+}
+
+// buildAddress returns the host and port of the instance using the address
+// selected by the policy, IPv6 addresses are enclosed in brackets
+func buildAddress(se *api.ServiceEntry, policy AddressPolicy) string {
+	return net.JoinHostPort(selectAddress(se, policy), strconv.Itoa(se.Service.Port))
+}
+
+func selectAddress(se *api.ServiceEntry, policy AddressPolicy) string {
+	var tagged map[string]string
+	if se.Node != nil {
+		tagged = se.Node.TaggedAddresses
+	}
+
+	if policy == AddressPreferIPv6 {
+		if a := tagged[string(AddressLANIPv6)]; a != "" {
+			return a
+		}
+
+		if a := defaultAddress(se); isIPv6(a) {
+			return a
+		}
+
+		if a := tagged[string(AddressLANIPv4)]; a != "" {
+			return a
+		}
+	} else if policy != AddressDefault {
+		if a := tagged[string(policy)]; a != "" {
+			return a
+		}
+	}
+
+	return defaultAddress(se)
+}
+
+// defaultAddress returns the service address or the node address when the
+// service does not have an address
+func defaultAddress(se *api.ServiceEntry) string {
+	if se.Service.Address != "" {
+		return se.Service.Address
+	}
+
+	if se.Node != nil {
+		return se.Node.Address
+	}
+
+	return ""
+}
+
+func isIPv6(a string) bool {
+	ip := net.ParseIP(a)
+	return ip != nil && ip.To4() == nil
+}
@@ -0,0 +1,106 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func testDualStackEntry() *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Service: &api.AgentService{Port: 8080},
+		Node: &api.Node{
+			Address: "10.0.0.1",
+			TaggedAddresses: map[string]string{
+				"lan":      "10.0.0.1",
+				"lan_ipv4": "10.0.0.1",
+				"lan_ipv6": "2001:db8::1",
+				"wan":      "203.0.113.1",
+			},
+		},
+	}
+}
+
+func TestBuildAddressJoinsIPv6Addresses(t *testing.T) {
+	se := &api.ServiceEntry{
+		Service: &api.AgentService{Address: "2001:db8::10", Port: 8080},
+		Node:    &api.Node{Address: "10.0.0.1"},
+	}
+
+	assert.Equal(t, "[2001:db8::10]:8080", buildAddress(se, AddressDefault))
+}
+
+func TestBuildAddressUsesNodeAddressWhenNoServiceAddress(t *testing.T) {
+	assert.Equal(t, "10.0.0.1:8080", buildAddress(testDualStackEntry(), AddressDefault))
+}
+
+func TestBuildAddressUsesTaggedAddress(t *testing.T) {
+	se := testDualStackEntry()
+
+	assert.Equal(t, "203.0.113.1:8080", buildAddress(se, AddressWAN))
+	assert.Equal(t, "[2001:db8::1]:8080", buildAddress(se, AddressLANIPv6))
+}
+
+func TestBuildAddressUsesTaggedAddressOverServiceAddress(t *testing.T) {
+	se := testDualStackEntry()
+	se.Service.Address = "10.0.0.2"
+
+	assert.Equal(t, "203.0.113.1:8080", buildAddress(se, AddressWAN))
+	assert.Equal(t, "10.0.0.2:8080", buildAddress(se, AddressDefault))
+}
+
+func TestBuildAddressFallsBackWhenTaggedAddressMissing(t *testing.T) {
+	se := testDualStackEntry()
+
+	assert.Equal(t, "10.0.0.1:8080", buildAddress(se, AddressWANIPv6))
+}
+
+func TestBuildAddressPrefersIPv6(t *testing.T) {
+	se := testDualStackEntry()
+	assert.Equal(t, "[2001:db8::1]:8080", buildAddress(se, AddressPreferIPv6))
+
+	delete(se.Node.TaggedAddresses, "lan_ipv6")
+	se.Service.Address = "2001:db8::10"
+	assert.Equal(t, "[2001:db8::10]:8080", buildAddress(se, AddressPreferIPv6))
+
+	se.Service.Address = ""
+	se.Node.TaggedAddresses["lan_ipv4"] = "10.0.0.3"
+	assert.Equal(t, "10.0.0.3:8080", buildAddress(se, AddressPreferIPv6))
+}
+
+func TestAddressingReturnsTargetPolicy(t *testing.T) {
+	a := Addressing{
+		AddressPolicy:       AddressLAN,
+		TargetAddressPolicy: map[string]AddressPolicy{"remote": AddressWAN},
+	}
+
+	assert.Equal(t, AddressLAN, a.policy("local"))
+	assert.Equal(t, AddressWAN, a.policy("remote"))
+}
+
+func TestExecuteServiceQueryAppliesTargetAddressPolicy(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+	healthMock.ExpectedCalls = make([]*mock.Call, 0)
+	healthMock.On("Service", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(func() []*api.ServiceEntry { return []*api.ServiceEntry{testDualStackEntry()} }, nil, nil)
+	sq.TargetAddressPolicy = map[string]AddressPolicy{"web": AddressPreferIPv6}
+
+	web, _ := sq.Execute("web", nil)
+	other, _ := sq.Execute("other", nil)
+
+	assert.Equal(t, "[2001:db8::1]:8080", web[0].Addr)
+	assert.Equal(t, "10.0.0.1:8080", other[0].Addr)
+}
+
+func TestExecutePreparedQueryAppliesAddressPolicy(t *testing.T) {
+	pq := setupPreparedQueryTests(t)
+	srs.Nodes = []api.ServiceEntry{*testDualStackEntry()}
+	pq.AddressPolicy = AddressWAN
+
+	ses, err := pq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.1:8080", ses[0].Addr)
+}
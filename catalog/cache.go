@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// Cache configures a query to use the Consul agent's local cache, with a large
+// number of clients per agent this substantially reduces the load on the
+// Consul servers.
+// See https://www.consul.io/api/index.html#agent-caching
+type Cache struct {
+	// UseCache requests that the agent serves the results from its local cache
+	UseCache bool
+	// MaxAge limits how old a cached result can be before the agent fetches
+	// a new result from the servers
+	MaxAge time.Duration
+	// StaleIfError allows the agent to return a stale cached result when the
+	// servers are unavailable
+	StaleIfError time.Duration
+}
+
+// queryOptions returns a copy of the given options with the cache settings
+// applied, the options passed by the caller are never modified
+func (c *Cache) queryOptions(options *api.QueryOptions) *api.QueryOptions {
+	o := &api.QueryOptions{}
+	if options != nil {
+		*o = *options
+	}
+
+	if c.UseCache {
+		o.UseCache = true
+		o.MaxAge = c.MaxAge
+		o.StaleIfError = c.StaleIfError
+	}
+
+	return o
+}
+
+// MetaReporter is implemented by queries which record the Consul QueryMeta
+// returned by the last execution of a query, this contains the index of the
+// result and if UseCache is set the cache hit and age information
+type MetaReporter interface {
+	LastMeta(name string) *api.QueryMeta
+}
+
+// metaStore records the last QueryMeta for each query name
+type metaStore struct {
+	mutex sync.Mutex
+	meta  map[string]*api.QueryMeta
+}
+
+// LastMeta returns the QueryMeta from the last execution of the query with
+// the given name, nil is returned if the query has not been executed
+func (m *metaStore) LastMeta(name string) *api.QueryMeta {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.meta[name]
+}
+
+func (m *metaStore) setMeta(name string, meta *api.QueryMeta) {
+	if meta == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.meta == nil {
+		m.meta = make(map[string]*api.QueryMeta)
+	}
+
+	m.meta[name] = meta
+}
@@ -0,0 +1,150 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// ErrorPolicy defines how a CompositeQuery handles an error from one of its
+// sources
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyFail returns an error when any source returns an error
+	ErrorPolicyFail ErrorPolicy = iota
+	// ErrorPolicyIgnore ignores the failed source and returns the endpoints
+	// from the other sources, an error is only returned when all sources fail
+	ErrorPolicyIgnore
+	// ErrorPolicyLastKnown uses the endpoints from the last successful
+	// execution of the failed source, an error is returned if the source has
+	// never succeeded
+	ErrorPolicyLastKnown
+)
+
+// CompositeSource is a query used by the CompositeQuery, Name overrides the
+// name passed to Execute allowing a source to query a different service or
+// prepared query
+type CompositeSource struct {
+	Query Query
+	Name  string
+}
+
+// CompositeQuery implements a query which executes several queries and merges
+// their results, endpoints with the same address are only returned once.
+// This can be used when a service is registered under multiple names or when
+// endpoints from a prepared query and the service catalog should be combined.
+type CompositeQuery struct {
+	sources   []CompositeSource
+	mutex     sync.Mutex
+	lastKnown map[string][]ServiceEntry
+
+	// ErrorPolicy defines how errors from the sources are handled, defaults to
+	// ErrorPolicyFail
+	ErrorPolicy ErrorPolicy
+}
+
+// NewCompositeQuery creates a new CompositeQuery for the given sources
+func NewCompositeQuery(sources ...CompositeSource) *CompositeQuery {
+	return &CompositeQuery{
+		sources:   sources,
+		lastKnown: make(map[string][]ServiceEntry),
+	}
+}
+
+// Execute runs all of the source queries concurrently and returns the merged
+// list of endpoints, the order of the sources determines which entry is
+// returned when more than one source returns the same address
+func (c *CompositeQuery) Execute(name string, options *api.QueryOptions) (ses []ServiceEntry, err error) {
+	_, span := startSpan(context.Background(), "catalog.CompositeQuery.Execute", name)
+	defer func() { endSpan(span, ses, err) }()
+
+	results := make([][]ServiceEntry, len(c.sources))
+	errs := make([]error, len(c.sources))
+
+	wg := sync.WaitGroup{}
+	for i, s := range c.sources {
+		wg.Add(1)
+
+		go func(i int, s CompositeSource) {
+			defer wg.Done()
+
+			n := name
+			if s.Name != "" {
+				n = s.Name
+			}
+
+			results[i], errs[i] = s.Query.Execute(n, options)
+		}(i, s)
+	}
+	wg.Wait()
+
+	failed := 0
+
+	for i := range c.sources {
+		key := fmt.Sprintf("%d/%s", i, name)
+
+		if errs[i] == nil {
+			c.setLastKnown(key, results[i])
+			continue
+		}
+
+		failed++
+		err = multierror.Append(err, errs[i])
+
+		switch c.ErrorPolicy {
+		case ErrorPolicyFail:
+			return nil, err
+		case ErrorPolicyLastKnown:
+			lk, ok := c.getLastKnown(key)
+			if !ok {
+				return nil, err
+			}
+
+			results[i] = lk
+		}
+	}
+
+	if c.ErrorPolicy == ErrorPolicyIgnore && failed == len(c.sources) {
+		return nil, err
+	}
+
+	return mergeEntries(results), nil
+}
+
+func (c *CompositeQuery) setLastKnown(key string, ses []ServiceEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastKnown[key] = ses
+}
+
+func (c *CompositeQuery) getLastKnown(key string) ([]ServiceEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ses, ok := c.lastKnown[key]
+	return ses, ok
+}
+
+// mergeEntries flattens the results removing entries with duplicate addresses
+func mergeEntries(results [][]ServiceEntry) []ServiceEntry {
+	ses := make([]ServiceEntry, 0)
+	seen := make(map[string]bool)
+
+	for _, r := range results {
+		for _, se := range r {
+			if seen[se.Addr] {
+				continue
+			}
+
+			seen[se.Addr] = true
+			ses = append(ses, se)
+		}
+	}
+
+	return ses
+}
@@ -0,0 +1,109 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var oldServiceMock *MockQuery
+var newServiceMock *MockQuery
+
+func setupCompositeQueryTests(t *testing.T) *CompositeQuery {
+	oldServiceMock = &MockQuery{}
+	oldServiceMock.On("Execute", "old", mock.Anything).Return(func() []ServiceEntry {
+		return []ServiceEntry{
+			ServiceEntry{Addr: "localhost:8080"},
+			ServiceEntry{Addr: "localhost:8081"},
+		}
+	}, nil)
+
+	newServiceMock = &MockQuery{}
+	newServiceMock.On("Execute", "new", mock.Anything).Return(func() []ServiceEntry {
+		return []ServiceEntry{
+			ServiceEntry{Addr: "localhost:8081"},
+			ServiceEntry{Addr: "localhost:8082"},
+		}
+	}, nil)
+
+	return NewCompositeQuery(
+		CompositeSource{Query: oldServiceMock, Name: "old"},
+		CompositeSource{Query: newServiceMock},
+		CompositeSource{Query: NewStaticQuery(ServiceEntry{Addr: "localhost:9090"})},
+	)
+}
+
+func setCompositeSourceError() {
+	newServiceMock.ExpectedCalls = make([]*mock.Call, 0)
+	newServiceMock.On("Execute", "new", mock.Anything).Return(nil, fmt.Errorf("Boom"))
+}
+
+func TestExecuteCompositeQueryMergesEntries(t *testing.T) {
+	cq := setupCompositeQueryTests(t)
+
+	entries, err := cq.Execute("new", nil)
+
+	assert.NoError(t, err)
+	oldServiceMock.AssertCalled(t, "Execute", "old", mock.Anything)
+	newServiceMock.AssertCalled(t, "Execute", "new", mock.Anything)
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "localhost:8080", entries[0].Addr)
+	assert.Equal(t, "localhost:8081", entries[1].Addr)
+	assert.Equal(t, "localhost:8082", entries[2].Addr)
+	assert.Equal(t, "localhost:9090", entries[3].Addr)
+}
+
+func TestExecuteCompositeQueryReturnsErrorWhenFailPolicy(t *testing.T) {
+	cq := setupCompositeQueryTests(t)
+	setCompositeSourceError()
+
+	_, err := cq.Execute("new", nil)
+
+	assert.Error(t, err)
+}
+
+func TestExecuteCompositeQueryIgnoresErrorWhenIgnorePolicy(t *testing.T) {
+	cq := setupCompositeQueryTests(t)
+	cq.ErrorPolicy = ErrorPolicyIgnore
+	setCompositeSourceError()
+
+	entries, err := cq.Execute("new", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestExecuteCompositeQueryReturnsErrorWhenAllSourcesFailAndIgnorePolicy(t *testing.T) {
+	m := &MockQuery{}
+	m.On("Execute", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Boom"))
+	cq := NewCompositeQuery(CompositeSource{Query: m})
+	cq.ErrorPolicy = ErrorPolicyIgnore
+
+	_, err := cq.Execute("new", nil)
+
+	assert.Error(t, err)
+}
+
+func TestExecuteCompositeQueryUsesLastKnownWhenLastKnownPolicy(t *testing.T) {
+	cq := setupCompositeQueryTests(t)
+	cq.ErrorPolicy = ErrorPolicyLastKnown
+	cq.Execute("new", nil)
+	setCompositeSourceError()
+
+	entries, err := cq.Execute("new", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+func TestExecuteCompositeQueryReturnsErrorWhenNoLastKnownAndLastKnownPolicy(t *testing.T) {
+	cq := setupCompositeQueryTests(t)
+	cq.ErrorPolicy = ErrorPolicyLastKnown
+	setCompositeSourceError()
+
+	_, err := cq.Execute("new", nil)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package catalog
+
+import (
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	// ServiceResolver is the kind of the service-resolver config entry
+	ServiceResolver = "service-resolver"
+	// ServiceSplitter is the kind of the service-splitter config entry
+	ServiceSplitter = "service-splitter"
+)
+
+// ServiceResolverConfigEntry defines which instances of a service are
+// returned when the service is resolved, the instances can be divided into
+// named subsets, traffic can be redirected to another service or can fail
+// over to another service or datacenter when no instances are healthy.
+// See https://www.consul.io/docs/agent/config-entries/service-resolver.html
+type ServiceResolverConfigEntry struct {
+	Kind          string
+	Name          string
+	DefaultSubset string                             `json:",omitempty"`
+	Subsets       map[string]ServiceResolverSubset   `json:",omitempty"`
+	Redirect      *ServiceResolverRedirect           `json:",omitempty"`
+	Failover      map[string]ServiceResolverFailover `json:",omitempty"`
+}
+
+// ServiceResolverSubset selects a subset of the instances of a service using
+// a filter expression
+type ServiceResolverSubset struct {
+	Filter      string `json:",omitempty"`
+	OnlyPassing bool   `json:",omitempty"`
+}
+
+// ServiceResolverRedirect resolves a different service, subset or
+// datacenter in place of the service
+type ServiceResolverRedirect struct {
+	Service       string `json:",omitempty"`
+	ServiceSubset string `json:",omitempty"`
+	Datacenter    string `json:",omitempty"`
+}
+
+// ServiceResolverFailover defines the targets which are resolved in order
+// when a subset has no healthy instances
+type ServiceResolverFailover struct {
+	Service       string   `json:",omitempty"`
+	ServiceSubset string   `json:",omitempty"`
+	Datacenters   []string `json:",omitempty"`
+}
+
+// ServiceSplitterConfigEntry splits the traffic for a service between
+// different services or subsets by percentage.
+// See https://www.consul.io/docs/agent/config-entries/service-splitter.html
+type ServiceSplitterConfigEntry struct {
+	Kind   string
+	Name   string
+	Splits []ServiceSplit `json:",omitempty"`
+}
+
+// ServiceSplit defines the percentage of traffic sent to a service or subset,
+// the weights of all splits in the config entry total 100
+type ServiceSplit struct {
+	Weight        float32
+	Service       string `json:",omitempty"`
+	ServiceSubset string `json:",omitempty"`
+}
+
+// ConsulConfigEntries defines an interface to read the config entries used
+// for traffic management, the github.com/hashicorp/consul/api package does
+// not support config entries so the entries are read using the raw API.
+// A nil entry is returned when no config entry exists for the service.
+type ConsulConfigEntries interface {
+	ServiceResolver(name string, q *api.QueryOptions) (*ServiceResolverConfigEntry, error)
+	ServiceSplitter(name string, q *api.QueryOptions) (*ServiceSplitterConfigEntry, error)
+}
+
+// configEntries implements ConsulConfigEntries using the Consul raw API
+type configEntries struct {
+	raw *api.Raw
+}
+
+func (c *configEntries) ServiceResolver(name string, q *api.QueryOptions) (*ServiceResolverConfigEntry, error) {
+	entry := &ServiceResolverConfigEntry{}
+
+	found, err := c.get(ServiceResolver, name, entry, q)
+	if !found {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (c *configEntries) ServiceSplitter(name string, q *api.QueryOptions) (*ServiceSplitterConfigEntry, error) {
+	entry := &ServiceSplitterConfigEntry{}
+
+	found, err := c.get(ServiceSplitter, name, entry, q)
+	if !found {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// get reads the config entry of the given kind into out, false is returned
+// when the entry does not exist or there is an error
+func (c *configEntries) get(kind, name string, out interface{}, q *api.QueryOptions) (bool, error) {
+	_, err := c.raw.Query("/v1/config/"+kind+"/"+name, out, q)
+	if err != nil {
+		// the api package does not expose the status code of the response
+		if strings.HasPrefix(err.Error(), "Unexpected response code: 404") {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
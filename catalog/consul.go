@@ -19,6 +19,9 @@ type ConsulPreparedQuery interface {
 		*api.PreparedQueryExecuteResponse, *api.QueryMeta, error)
 }
 
+// ConsulAgent defines an interface which adheres to the required functions from
+// the github.com/hashicorp/consul/api Agent struct
 type ConsulAgent interface {
 	ConnectCARoots(q *api.QueryOptions) (*api.CARootList, *api.QueryMeta, error)
+	Self() (map[string]map[string]interface{}, error)
 }
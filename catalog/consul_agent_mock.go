@@ -14,3 +14,19 @@ func (a *MockConsulAgent) ConnectCARoots(q *api.QueryOptions) (*api.CARootList,
 
 	return args.Get(0).(*api.CARootList), nil, args.Error(2)
 }
+
+func (a *MockConsulAgent) Self() (map[string]map[string]interface{}, error) {
+	args := a.Called()
+
+	return args.Get(0).(map[string]map[string]interface{}), args.Error(1)
+}
+
+func (a *MockConsulAgent) Services() (map[string]*api.AgentService, error) {
+	args := a.Called()
+
+	if s := args.Get(0); s != nil {
+		return s.(map[string]*api.AgentService), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
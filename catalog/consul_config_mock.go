@@ -0,0 +1,34 @@
+package catalog
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockConsulConfigEntries is a mock implementation of the ConsulConfigEntries
+// interface used for testing
+type MockConsulConfigEntries struct {
+	mock.Mock
+}
+
+// ServiceResolver returns the service-resolver config entry for the service
+func (m *MockConsulConfigEntries) ServiceResolver(name string, q *api.QueryOptions) (*ServiceResolverConfigEntry, error) {
+	args := m.Called(name, q)
+
+	if e := args.Get(0); e != nil {
+		return e.(*ServiceResolverConfigEntry), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
+
+// ServiceSplitter returns the service-splitter config entry for the service
+func (m *MockConsulConfigEntries) ServiceSplitter(name string, q *api.QueryOptions) (*ServiceSplitterConfigEntry, error) {
+	args := m.Called(name, q)
+
+	if e := args.Get(0); e != nil {
+		return e.(*ServiceSplitterConfigEntry), args.Error(1)
+	}
+
+	return nil, args.Error(1)
+}
@@ -0,0 +1,288 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// maxRedirects limits the number of service-resolver redirects followed when
+// resolving a service, this protects against redirect loops
+const maxRedirects = 8
+
+// splitWeightScale converts the split percentages, which have a precision of
+// two decimal places, to integer weights
+const splitWeightScale = 100
+
+// DiscoveryChainQuery implements a query which applies the service-resolver
+// and service-splitter config entries for the service, this allows the
+// traffic management configured in Consul to be used by gRPC clients which
+// do not use Envoy.
+//
+// When a service-splitter exists the endpoints for each split are returned
+// with weights in proportion to the percentage of the split, the resolver
+// should be created with Weighted set and used with the WeightedRoundRobin
+// balancer. Splits which target a service with its own splitter are not
+// split further.
+//
+// The service-resolver subsets, default subset, redirect and failover are
+// applied when resolving each target. Subset filters support the ==, !=,
+// in, not in, contains, not contains, is empty and is not empty operators
+// of the Consul filtering language joined with and / or.
+type DiscoveryChainQuery struct {
+	config ConsulConfigEntries
+
+	*ServiceQuery
+}
+
+// target is a service and subset which is resolved to a list of endpoints
+type target struct {
+	service    string
+	subset     string
+	datacenter string
+}
+
+// NewDiscoveryChainQuery creates a new DiscoveryChainQuery configured with a
+// Consul API client, the embedded ServiceQuery is used to query the
+// instances of each target and can be used to configure the query
+func NewDiscoveryChainQuery(client *api.Client, useConnect bool) *DiscoveryChainQuery {
+	return &DiscoveryChainQuery{
+		config:       &configEntries{client.Raw()},
+		ServiceQuery: NewServiceQuery(client, useConnect),
+	}
+}
+
+// Execute resolves the service applying the config entries and returns the
+// merged list of endpoints
+func (d *DiscoveryChainQuery) Execute(name string, options *api.QueryOptions) (ses []ServiceEntry, err error) {
+	ctx, span := startSpan(context.Background(), "catalog.DiscoveryChainQuery.Execute", name)
+	defer func() { endSpan(span, ses, err) }()
+
+	splitter, err := d.config.ServiceSplitter(name, d.configOptions(options))
+	if err != nil {
+		return nil, err
+	}
+
+	// the QueryMeta and panic state are recorded under the name of the target
+	// rather than the services it resolves to
+	e := &execution{}
+	defer func() {
+		if err == nil {
+			d.record(name, e)
+		}
+	}()
+
+	if splitter == nil || len(splitter.Splits) == 0 {
+		return d.resolve(ctx, e, target{service: name}, options, true)
+	}
+
+	groups := make([][]ServiceEntry, 0)
+
+	for _, split := range splitter.Splits {
+		if split.Weight <= 0 {
+			continue
+		}
+
+		t := target{service: split.Service, subset: split.ServiceSubset}
+		if t.service == "" {
+			t.service = name
+		}
+
+		ses, err := d.resolve(ctx, e, t, options, true)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, splitWeights(ses, split.Weight))
+	}
+
+	return mergeWeights(groups), nil
+}
+
+// resolve returns the endpoints for the target applying the service-resolver
+// for the service, when failover is true and the target has no endpoints the
+// failover targets are resolved in order
+func (d *DiscoveryChainQuery) resolve(ctx context.Context, e *execution, t target, options *api.QueryOptions, failover bool) ([]ServiceEntry, error) {
+	var resolver *ServiceResolverConfigEntry
+	var err error
+
+	for i := 0; ; i++ {
+		resolver, err = d.config.ServiceResolver(t.service, d.configOptions(options))
+		if err != nil {
+			return nil, err
+		}
+
+		if resolver == nil || resolver.Redirect == nil {
+			break
+		}
+
+		if i == maxRedirects {
+			return nil, fmt.Errorf("Too many redirects resolving service %s", t.service)
+		}
+
+		t = redirectTarget(t, resolver.Redirect)
+	}
+
+	var subset ServiceResolverSubset
+
+	if resolver != nil {
+		if t.subset == "" {
+			t.subset = resolver.DefaultSubset
+		}
+
+		if t.subset != "" {
+			var ok bool
+			subset, ok = resolver.Subsets[t.subset]
+			if !ok {
+				return nil, fmt.Errorf("Subset %s is not defined for service %s", t.subset, t.service)
+			}
+		}
+	}
+
+	f, err := parseFilter(subset.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &api.QueryOptions{}
+	if options != nil {
+		*o = *options
+	}
+
+	if t.datacenter != "" {
+		o.Datacenter = t.datacenter
+	}
+
+	ses, err := d.execute(ctx, e, t.service, o, d.PassingOnly || subset.OnlyPassing, f)
+	if err != nil || len(ses) > 0 || !failover || resolver == nil {
+		return ses, err
+	}
+
+	fo, ok := resolver.Failover[t.subset]
+	if !ok {
+		fo, ok = resolver.Failover["*"]
+	}
+
+	if !ok {
+		return ses, nil
+	}
+
+	for _, ft := range failoverTargets(t, fo) {
+		ses, err = d.resolve(ctx, e, ft, options, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ses) > 0 {
+			return ses, nil
+		}
+	}
+
+	return ses, nil
+}
+
+// configOptions returns the options used to read the config entries, config
+// entries are replicated to all datacenters and are always read from the
+// local datacenter
+func (d *DiscoveryChainQuery) configOptions(options *api.QueryOptions) *api.QueryOptions {
+	o := d.queryOptions(options)
+	o.Datacenter = ""
+
+	return o
+}
+
+// redirectTarget returns the target of a service-resolver redirect, fields
+// which are not set in the redirect are kept from the original target
+func redirectTarget(t target, r *ServiceResolverRedirect) target {
+	rt := target{service: t.service, datacenter: t.datacenter}
+
+	if r.Service != "" {
+		rt.service = r.Service
+	}
+
+	if r.ServiceSubset != "" {
+		rt.subset = r.ServiceSubset
+	} else if r.Service == "" {
+		rt.subset = t.subset
+	}
+
+	if r.Datacenter != "" {
+		rt.datacenter = r.Datacenter
+	}
+
+	return rt
+}
+
+// failoverTargets returns the targets for a failover, a target is returned
+// for each of the failover datacenters
+func failoverTargets(t target, fo ServiceResolverFailover) []target {
+	ft := target{service: t.service, subset: t.subset, datacenter: t.datacenter}
+
+	if fo.Service != "" {
+		ft.service = fo.Service
+		ft.subset = ""
+	}
+
+	if fo.ServiceSubset != "" {
+		ft.subset = fo.ServiceSubset
+	}
+
+	if len(fo.Datacenters) == 0 {
+		return []target{ft}
+	}
+
+	targets := make([]target, 0, len(fo.Datacenters))
+	for _, dc := range fo.Datacenters {
+		ft.datacenter = dc
+		targets = append(targets, ft)
+	}
+
+	return targets
+}
+
+// splitWeights scales the weights of the endpoints so that their total is in
+// proportion to the split percentage, the relative weights of the endpoints
+// within the split are kept
+func splitWeights(ses []ServiceEntry, percentage float32) []ServiceEntry {
+	total := 0
+	for _, se := range ses {
+		total += se.Weight
+	}
+
+	split := int(percentage*splitWeightScale + 0.5)
+
+	for i := range ses {
+		w := split * ses[i].Weight / total
+
+		// every endpoint in the split should receive some traffic
+		if w < 1 {
+			w = 1
+		}
+
+		ses[i].Weight = w
+	}
+
+	return ses
+}
+
+// mergeWeights flattens the split endpoints, the weights of endpoints which
+// are returned by more than one split are added together
+func mergeWeights(groups [][]ServiceEntry) []ServiceEntry {
+	ses := make([]ServiceEntry, 0)
+	index := make(map[string]int)
+
+	for _, g := range groups {
+		for _, se := range g {
+			if i, ok := index[se.Addr]; ok {
+				ses[i].Weight += se.Weight
+				continue
+			}
+
+			index[se.Addr] = len(ses)
+			ses = append(ses, se)
+		}
+	}
+
+	return ses
+}
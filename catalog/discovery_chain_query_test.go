@@ -0,0 +1,245 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var configMock *MockConsulConfigEntries
+var chainServices map[string][]*api.ServiceEntry
+
+func testChainEntry(service, addr, version string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Service: &api.AgentService{
+			Service: service,
+			Address: addr,
+			Port:    8080,
+			Meta:    map[string]string{"version": version},
+		},
+		Node: &api.Node{Datacenter: "dc1"},
+	}
+}
+
+func setupDiscoveryChainTests(t *testing.T) *DiscoveryChainQuery {
+	chainServices = map[string][]*api.ServiceEntry{
+		"web/": []*api.ServiceEntry{
+			testChainEntry("web", "10.0.0.1", "v1"),
+			testChainEntry("web", "10.0.0.2", "v1"),
+			testChainEntry("web", "10.0.0.3", "v2"),
+		},
+		"api/":    []*api.ServiceEntry{testChainEntry("api", "10.0.1.1", "v1")},
+		"web/dc2": []*api.ServiceEntry{testChainEntry("web", "10.0.2.1", "v1")},
+	}
+
+	healthMock = &MockConsulHealth{}
+	for k := range chainServices {
+		key := k
+		parts := strings.SplitN(key, "/", 2)
+
+		healthMock.On("Service", parts[0], "", mock.Anything, mock.MatchedBy(func(q *api.QueryOptions) bool {
+			return q.Datacenter == parts[1]
+		})).Return(func() []*api.ServiceEntry { return chainServices[key] }, nil, nil)
+	}
+
+	configMock = &MockConsulConfigEntries{}
+
+	return &DiscoveryChainQuery{
+		config:       configMock,
+		ServiceQuery: &ServiceQuery{client: healthMock, agent: &MockConsulAgent{}, PassingOnly: true},
+	}
+}
+
+func TestExecuteDiscoveryChainReturnsAllEntriesWithoutConfigEntries(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(nil, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(nil, nil)
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, 1, entries[0].Weight)
+}
+
+func TestExecuteDiscoveryChainAppliesDefaultSubset(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(nil, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{
+		DefaultSubset: "v2",
+		Subsets: map[string]ServiceResolverSubset{
+			"v2": ServiceResolverSubset{Filter: "Service.Meta.version == v2"},
+		},
+	}, nil)
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "10.0.0.3:8080", entries[0].Addr)
+}
+
+func TestExecuteDiscoveryChainSplitsWeightsBetweenSubsets(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(&ServiceSplitterConfigEntry{
+		Splits: []ServiceSplit{
+			ServiceSplit{Weight: 90, ServiceSubset: "v1"},
+			ServiceSplit{Weight: 10, ServiceSubset: "v2"},
+		},
+	}, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{
+		Subsets: map[string]ServiceResolverSubset{
+			"v1": ServiceResolverSubset{Filter: "Service.Meta.version == v1"},
+			"v2": ServiceResolverSubset{Filter: "Service.Meta.version == v2"},
+		},
+	}, nil)
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, 4500, entries[0].Weight)
+	assert.Equal(t, 4500, entries[1].Weight)
+	assert.Equal(t, "10.0.0.3:8080", entries[2].Addr)
+	assert.Equal(t, 1000, entries[2].Weight)
+}
+
+func TestExecuteDiscoveryChainSplitsToOtherService(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(&ServiceSplitterConfigEntry{
+		Splits: []ServiceSplit{
+			ServiceSplit{Weight: 50},
+			ServiceSplit{Weight: 50, Service: "api"},
+		},
+	}, nil)
+	configMock.On("ServiceResolver", mock.Anything, mock.Anything).Return(nil, nil)
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+	assert.Equal(t, "10.0.1.1:8080", entries[3].Addr)
+	assert.Equal(t, 5000, entries[3].Weight)
+}
+
+func TestExecuteDiscoveryChainFollowsRedirect(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(nil, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{
+		Redirect: &ServiceResolverRedirect{Service: "api"},
+	}, nil)
+	configMock.On("ServiceResolver", "api", mock.Anything).Return(nil, nil)
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "10.0.1.1:8080", entries[0].Addr)
+}
+
+func TestExecuteDiscoveryChainReturnsErrorForRedirectLoop(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(nil, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{
+		Redirect: &ServiceResolverRedirect{Service: "api"},
+	}, nil)
+	configMock.On("ServiceResolver", "api", mock.Anything).Return(&ServiceResolverConfigEntry{
+		Redirect: &ServiceResolverRedirect{Service: "web"},
+	}, nil)
+
+	_, err := dq.Execute("web", nil)
+
+	assert.Error(t, err)
+}
+
+func TestExecuteDiscoveryChainFailsOverToDatacenter(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	chainServices["web/"] = []*api.ServiceEntry{}
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(nil, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{
+		Failover: map[string]ServiceResolverFailover{
+			"*": ServiceResolverFailover{Datacenters: []string{"dc3", "dc2"}},
+		},
+	}, nil)
+	healthMock.On("Service", "web", "", mock.Anything, mock.Anything).Return(nil, nil, nil)
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "10.0.2.1:8080", entries[0].Addr)
+}
+
+func TestExecuteDiscoveryChainReturnsErrorForUnknownSubset(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(&ServiceSplitterConfigEntry{
+		Splits: []ServiceSplit{ServiceSplit{Weight: 100, ServiceSubset: "v3"}},
+	}, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{}, nil)
+
+	_, err := dq.Execute("web", nil)
+
+	assert.Error(t, err)
+}
+
+func TestExecuteDiscoveryChainUsesOnlyPassingForSubset(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	dq.PassingOnly = false
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(nil, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{
+		DefaultSubset: "v1",
+		Subsets: map[string]ServiceResolverSubset{
+			"v1": ServiceResolverSubset{Filter: "Service.Meta.version == v1", OnlyPassing: true},
+		},
+	}, nil)
+
+	_, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	healthMock.AssertCalled(t, "Service", "web", "", true, mock.Anything)
+}
+
+func TestExecuteDiscoveryChainRecordsStateUnderTarget(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	dq.PanicThreshold = 0.5
+	critical := testChainEntry("api", "10.0.1.1", "v1")
+	critical.Checks = api.HealthChecks{&api.HealthCheck{Status: api.HealthCritical}}
+	healthMock.ExpectedCalls = nil
+	healthMock.On("Service", "web", "", mock.Anything, mock.Anything).Return(func() []*api.ServiceEntry { return chainServices["web/"] }, &api.QueryMeta{LastIndex: 10}, nil)
+	healthMock.On("Service", "api", "", mock.Anything, mock.Anything).Return(func() []*api.ServiceEntry { return []*api.ServiceEntry{critical} }, &api.QueryMeta{LastIndex: 20}, nil)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(&ServiceSplitterConfigEntry{
+		Splits: []ServiceSplit{
+			ServiceSplit{Weight: 50},
+			ServiceSplit{Weight: 50, Service: "api"},
+		},
+	}, nil)
+	configMock.On("ServiceResolver", mock.Anything, mock.Anything).Return(nil, nil)
+
+	_, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(20), dq.LastMeta("web").LastIndex)
+	assert.True(t, dq.InPanic("web"))
+	assert.Nil(t, dq.LastMeta("api"))
+	assert.False(t, dq.InPanic("api"))
+}
+
+func TestExecuteDiscoveryChainRecordsStateOfRedirectUnderTarget(t *testing.T) {
+	dq := setupDiscoveryChainTests(t)
+	healthMock.ExpectedCalls = nil
+	healthMock.On("Service", "api", "", mock.Anything, mock.Anything).Return(func() []*api.ServiceEntry { return chainServices["api/"] }, &api.QueryMeta{LastIndex: 20}, nil)
+	configMock.On("ServiceSplitter", "web", mock.Anything).Return(nil, nil)
+	configMock.On("ServiceResolver", "web", mock.Anything).Return(&ServiceResolverConfigEntry{
+		Redirect: &ServiceResolverRedirect{Service: "api"},
+	}, nil)
+	configMock.On("ServiceResolver", "api", mock.Anything).Return(nil, nil)
+
+	_, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(20), dq.LastMeta("web").LastIndex)
+	assert.Nil(t, dq.LastMeta("api"))
+}
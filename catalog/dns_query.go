@@ -0,0 +1,195 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/hashicorp/consul/api"
+	"github.com/miekg/dns"
+)
+
+// DNSQuery implements the logic to lookup a service using SRV records from
+// Consul's DNS interface, this can be used in environments where the HTTP
+// API is not available.
+// The name passed to Execute is the service name optionally prefixed with a
+// tag, e.g. "web" or "v1.web", tags are not supported for Connect services
+type DNSQuery struct {
+	server     string
+	useConnect bool
+	client     *dns.Client
+
+	// Domain is the Consul DNS domain, defaults to consul
+	Domain string
+
+	// Datacenter to query, when empty the datacenter of the agent answering the
+	// DNS query is used. Datacenter in the QueryOptions passed to Execute takes
+	// precedence.
+	Datacenter string
+
+	// TrustDomain is the Connect CA trust domain, this can not be retrieved
+	// over DNS and must be set when querying Connect services
+	TrustDomain string
+}
+
+// NewDNSQuery creates a new DNSQuery which sends queries to the given DNS
+// server address, e.g. "127.0.0.1:8600"
+// Setting the useConnect parameter to true will query the Consul Connect
+// service catalog and return the address of the Connect proxy associated with
+// the service
+func NewDNSQuery(server string, useConnect bool) *DNSQuery {
+	return &DNSQuery{
+		server:     server,
+		useConnect: useConnect,
+		client:     &dns.Client{Timeout: 5 * time.Second},
+		Domain:     "consul",
+	}
+}
+
+// Execute the SRV lookup against the DNS server and build a list of
+// ServiceEntry structs which can be used by the resolver
+func (d *DNSQuery) Execute(name string, options *api.QueryOptions) (ses []ServiceEntry, err error) {
+	_, span := startSpan(context.Background(), "catalog.DNSQuery.Execute", name)
+	defer func() { endSpan(span, ses, err) }()
+
+	if d.useConnect && d.TrustDomain == "" {
+		return nil, fmt.Errorf("TrustDomain must be set to query Connect services over DNS")
+	}
+
+	// Consul does not support tags in the DNS name of Connect services
+	if d.useConnect && name != serviceName(name) {
+		return nil, fmt.Errorf("Tags are not supported when querying Connect services over DNS")
+	}
+
+	dc := d.Datacenter
+	if options != nil && options.Datacenter != "" {
+		dc = options.Datacenter
+	}
+
+	setDatacenter(span, dc)
+
+	m := new(dns.Msg)
+	m.SetQuestion(d.buildName(name, dc), dns.TypeSRV)
+
+	r, err := d.exchange(m)
+	if err != nil {
+		return nil, err
+	}
+
+	ses = make([]ServiceEntry, 0)
+
+	// Consul returns NXDOMAIN when there are no healthy instances of a service
+	if r.Rcode == dns.RcodeNameError {
+		return ses, nil
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("DNS query for %s failed: %s", name, dns.RcodeToString[r.Rcode])
+	}
+
+	// Consul includes the addresses of the SRV targets in the additional section
+	hosts := make(map[string]string)
+	for _, rr := range r.Extra {
+		switch a := rr.(type) {
+		case *dns.A:
+			hosts[a.Hdr.Name] = a.A.String()
+		case *dns.AAAA:
+			hosts[a.Hdr.Name] = a.AAAA.String()
+		}
+	}
+
+	for _, rr := range r.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok || srv.Weight == 0 {
+			continue
+		}
+
+		host, ok := hosts[srv.Target]
+		if !ok {
+			host = strings.TrimSuffix(srv.Target, ".")
+		}
+
+		se := ServiceEntry{
+			Addr:   net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+			Weight: int(srv.Weight),
+		}
+
+		if d.useConnect {
+			se.CertURI = d.buildCert(name, dc, srv.Target)
+		}
+
+		ses = append(ses, se)
+	}
+
+	return ses, nil
+}
+
+// exchange sends the query over UDP retrying over TCP when the response has
+// been truncated
+func (d *DNSQuery) exchange(m *dns.Msg) (*dns.Msg, error) {
+	r, _, err := d.client.Exchange(m, d.server)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Truncated {
+		tcp := &dns.Client{Net: "tcp", Timeout: d.client.Timeout}
+
+		r, _, err = tcp.Exchange(m, d.server)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// buildName returns the DNS name for the service
+// [tag.]<service>.service[.datacenter].<domain> or
+// <service>.connect[.datacenter].<domain> for Connect services
+func (d *DNSQuery) buildName(name, dc string) string {
+	parts := []string{name, "service"}
+	if d.useConnect {
+		parts = []string{serviceName(name), "connect"}
+	}
+
+	if dc != "" {
+		parts = append(parts, dc)
+	}
+
+	parts = append(parts, d.Domain)
+
+	return dns.Fqdn(strings.Join(parts, "."))
+}
+
+func (d *DNSQuery) buildCert(name, dc, target string) connect.CertURI {
+	// the datacenter of the node is encoded in the SRV target
+	// <node>.node.<datacenter>.<domain>. or <hex ip>.addr.<datacenter>.<domain>.
+	// the domain may contain more than one label so it is removed before the
+	// datacenter label is read
+	if dc == "" {
+		suffix := "." + dns.Fqdn(d.Domain)
+		if strings.HasSuffix(strings.ToLower(target), strings.ToLower(suffix)) {
+			labels := dns.SplitDomainName(target[:len(target)-len(suffix)])
+			if len(labels) >= 3 {
+				dc = labels[len(labels)-1]
+			}
+		}
+	}
+
+	return &connect.SpiffeIDService{
+		Host:       d.TrustDomain,
+		Namespace:  "default",
+		Datacenter: dc,
+		Service:    serviceName(name),
+	}
+}
+
+// serviceName removes any tag prefix from the name
+func serviceName(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
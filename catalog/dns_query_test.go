@@ -0,0 +1,185 @@
+package catalog
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/hashicorp/consul/api"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+var dnsQuestions []string
+var dnsMutex sync.Mutex
+
+func testDNSQuestions() []string {
+	dnsMutex.Lock()
+	defer dnsMutex.Unlock()
+
+	return dnsQuestions
+}
+
+func setupDNSQueryTests(t *testing.T, useConnect bool) (*DNSQuery, func()) {
+	dnsMutex.Lock()
+	dnsQuestions = make([]string, 0)
+	dnsMutex.Unlock()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	s := &dns.Server{
+		PacketConn:        pc,
+		Handler:           dns.HandlerFunc(testDNSHandler),
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go s.ActivateAndServe()
+	<-started
+
+	return NewDNSQuery(pc.LocalAddr().String(), useConnect), func() { s.Shutdown() }
+}
+
+func testDNSHandler(w dns.ResponseWriter, r *dns.Msg) {
+	q := r.Question[0].Name
+
+	dnsMutex.Lock()
+	dnsQuestions = append(dnsQuestions, q)
+	dnsMutex.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	switch q {
+	case "web.service.consul.", "v1.web.service.dc2.consul.", "web.connect.consul.":
+		m.Answer = append(m.Answer,
+			&dns.SRV{
+				Hdr:    dns.RR_Header{Name: q, Rrtype: dns.TypeSRV, Class: dns.ClassINET},
+				Weight: 1,
+				Port:   8080,
+				Target: "node1.node.dc1.consul.",
+			},
+			&dns.SRV{
+				Hdr:    dns.RR_Header{Name: q, Rrtype: dns.TypeSRV, Class: dns.ClassINET},
+				Weight: 3,
+				Port:   8081,
+				Target: "node2.node.dc1.consul.",
+			},
+		)
+		m.Extra = append(m.Extra,
+			&dns.A{
+				Hdr: dns.RR_Header{Name: "node1.node.dc1.consul.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+				A:   net.ParseIP("10.0.0.1"),
+			},
+			&dns.AAAA{
+				Hdr:  dns.RR_Header{Name: "node2.node.dc1.consul.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET},
+				AAAA: net.ParseIP("fd00::2"),
+			},
+		)
+	case "web.connect.consul.example.com.":
+		m.Answer = append(m.Answer,
+			&dns.SRV{
+				Hdr:    dns.RR_Header{Name: q, Rrtype: dns.TypeSRV, Class: dns.ClassINET},
+				Weight: 1,
+				Port:   8080,
+				Target: "node1.node.dc3.consul.example.com.",
+			},
+		)
+	default:
+		m.Rcode = dns.RcodeNameError
+	}
+
+	w.WriteMsg(m)
+}
+
+func TestExecuteDNSQueryReturnsEntries(t *testing.T) {
+	dq, cleanup := setupDNSQueryTests(t, false)
+	defer cleanup()
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web.service.consul."}, testDNSQuestions())
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "10.0.0.1:8080", entries[0].Addr)
+	assert.Equal(t, 1, entries[0].Weight)
+	assert.Equal(t, "[fd00::2]:8081", entries[1].Addr)
+	assert.Equal(t, 3, entries[1].Weight)
+}
+
+func TestExecuteDNSQueryWithTagAndDatacenter(t *testing.T) {
+	dq, cleanup := setupDNSQueryTests(t, false)
+	defer cleanup()
+
+	entries, err := dq.Execute("v1.web", &api.QueryOptions{Datacenter: "dc2"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v1.web.service.dc2.consul."}, testDNSQuestions())
+	assert.Len(t, entries, 2)
+}
+
+func TestExecuteDNSQueryReturnsNoEntriesWhenServiceNotFound(t *testing.T) {
+	dq, cleanup := setupDNSQueryTests(t, false)
+	defer cleanup()
+
+	entries, err := dq.Execute("unknown", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestExecuteDNSQueryReturnsErrorWhenConnectAndNoTrustDomain(t *testing.T) {
+	dq, cleanup := setupDNSQueryTests(t, true)
+	defer cleanup()
+
+	_, err := dq.Execute("web", nil)
+
+	assert.Error(t, err)
+}
+
+func TestExecuteConnectDNSQueryReturnsValidCertURI(t *testing.T) {
+	dq, cleanup := setupDNSQueryTests(t, true)
+	defer cleanup()
+	dq.TrustDomain = "abc.com"
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web.connect.consul."}, testDNSQuestions())
+	assert.Len(t, entries, 2)
+
+	spiffeID := entries[0].CertURI.(*connect.SpiffeIDService)
+	assert.Equal(t, "abc.com", spiffeID.Host)
+	assert.Equal(t, "dc1", spiffeID.Datacenter)
+	assert.Equal(t, "web", spiffeID.Service)
+}
+
+func TestExecuteConnectDNSQueryReturnsCertURIWhenDomainHasMultipleLabels(t *testing.T) {
+	dq, cleanup := setupDNSQueryTests(t, true)
+	defer cleanup()
+	dq.TrustDomain = "abc.com"
+	dq.Domain = "consul.example.com"
+
+	entries, err := dq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	spiffeID := entries[0].CertURI.(*connect.SpiffeIDService)
+	assert.Equal(t, "dc3", spiffeID.Datacenter)
+}
+
+func TestExecuteConnectDNSQueryReturnsErrorWhenTagged(t *testing.T) {
+	dq, cleanup := setupDNSQueryTests(t, true)
+	defer cleanup()
+	dq.TrustDomain = "abc.com"
+
+	_, err := dq.Execute("v1.web", nil)
+
+	assert.Error(t, err)
+	assert.Len(t, testDNSQuestions(), 0)
+}
@@ -0,0 +1,156 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+)
+
+// FileQuery implements the logic to lookup a service from a local JSON or HCL
+// file, this allows services to be resolved without Consul for local
+// development and tests.
+// The file is not watched, its modification time and size are polled each
+// time the query is executed and the file is only parsed again when either
+// has changed. When used with the ConsulWatcher edits to the file are
+// returned as updates at the next poll interval.
+//
+// example HCL file:
+//
+//	service "test_grpc" {
+//	  address = "localhost:8080"
+//	}
+//
+//	service "test_grpc" {
+//	  address  = "localhost:8081"
+//	  weight   = 2
+//	  tags     = ["canary"]
+//	  cert_uri = "spiffe://abc.consul/ns/default/dc/dc1/svc/test_grpc"
+//	}
+//
+// example JSON file:
+//
+//	{
+//	  "service": {
+//	    "test_grpc": [
+//	      {"address": "localhost:8080"},
+//	      {"address": "localhost:8081", "weight": 2}
+//	    ]
+//	  }
+//	}
+type FileQuery struct {
+	path     string
+	mutex    sync.Mutex
+	modTime  time.Time
+	size     int64
+	services map[string][]ServiceEntry
+
+	// Logger reports files which can not be reloaded, defaults to a Logger
+	// which discards all messages
+	Logger Logger
+}
+
+type fileConfig struct {
+	Services map[string][]fileEndpoint `hcl:"service"`
+}
+
+type fileEndpoint struct {
+	Address string   `hcl:"address"`
+	CertURI string   `hcl:"cert_uri"`
+	Weight  int      `hcl:"weight"`
+	Tags    []string `hcl:"tags"`
+}
+
+// NewFileQuery creates a new FileQuery which reads services from the file at
+// the given path
+func NewFileQuery(path string) *FileQuery {
+	return &FileQuery{path: path}
+}
+
+// Execute returns the endpoints for the named service from the file, the
+// options are ignored.
+// If the file can not be read or parsed after it has been successfully loaded
+// the previously loaded endpoints are returned, this ensures a partially
+// written file does not stop the watcher. The error is logged and recorded on
+// the span.
+func (f *FileQuery) Execute(name string, options *api.QueryOptions) (ses []ServiceEntry, err error) {
+	_, span := startSpan(context.Background(), "catalog.FileQuery.Execute", name)
+	defer func() { endSpan(span, ses, err) }()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	err = f.reload()
+	if err != nil && f.services == nil {
+		return nil, err
+	}
+
+	if err != nil {
+		LoggerOrNop(f.Logger).Warn("unable to reload file, using previous endpoints", "path", f.path, "error", err)
+		span.RecordError(err)
+	}
+
+	ses = make([]ServiceEntry, len(f.services[name]))
+	copy(ses, f.services[name])
+
+	return ses, nil
+}
+
+// reload parses the file if it has changed since it was last loaded
+func (f *FileQuery) reload() error {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	if f.services != nil && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+
+	d, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	// HCL is a superset of JSON so both formats can be decoded with the HCL parser
+	fc := fileConfig{}
+	err = hcl.Decode(&fc, string(d))
+	if err != nil {
+		return fmt.Errorf("Unable to parse file %s: %s", f.path, err)
+	}
+
+	services := make(map[string][]ServiceEntry)
+	for name, endpoints := range fc.Services {
+		ses := make([]ServiceEntry, 0)
+
+		for _, e := range endpoints {
+			se := ServiceEntry{Addr: e.Address, Weight: e.Weight, Tags: e.Tags}
+			if se.Weight == 0 {
+				se.Weight = 1
+			}
+
+			if e.CertURI != "" {
+				se.CertURI, err = connect.ParseCertURIFromString(e.CertURI)
+				if err != nil {
+					return fmt.Errorf("Invalid cert_uri for service %s: %s", name, err)
+				}
+			}
+
+			ses = append(ses, se)
+		}
+
+		services[name] = ses
+	}
+
+	f.services = services
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testHCLFile = `
+service "test_grpc" {
+  address = "localhost:8080"
+}
+
+service "test_grpc" {
+  address  = "localhost:8081"
+  weight   = 2
+  cert_uri = "spiffe://abc.com/ns/default/dc/dc1/svc/test_grpc"
+  tags     = ["canary"]
+}
+`
+
+var testJSONFile = `
+{
+  "service": {
+    "test_grpc": [
+      {"address": "localhost:8080"},
+      {"address": "localhost:8081", "weight": 2}
+    ]
+  }
+}
+`
+
+func setupFileQueryTests(t *testing.T, contents string) (*FileQuery, string) {
+	f, err := ioutil.TempFile("", "file_query")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.WriteString(contents)
+	f.Close()
+
+	return NewFileQuery(f.Name()), f.Name()
+}
+
+// writeTestFile updates the file and moves the modification time forward so
+// the change is detected regardless of the file system time resolution
+func writeTestFile(path, contents string) {
+	ioutil.WriteFile(path, []byte(contents), 0644)
+	future := time.Now().Add(1 * time.Minute)
+	os.Chtimes(path, future, future)
+}
+
+func TestExecuteFileQueryReturnsEntriesFromHCL(t *testing.T) {
+	fq, path := setupFileQueryTests(t, testHCLFile)
+	defer os.Remove(path)
+
+	entries, err := fq.Execute("test_grpc", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "localhost:8080", entries[0].Addr)
+	assert.Equal(t, 1, entries[0].Weight)
+	assert.Nil(t, entries[0].CertURI)
+	assert.Equal(t, "localhost:8081", entries[1].Addr)
+	assert.Equal(t, 2, entries[1].Weight)
+	assert.Equal(t, []string{"canary"}, entries[1].Tags)
+
+	spiffeID := entries[1].CertURI.(*connect.SpiffeIDService)
+	assert.Equal(t, "abc.com", spiffeID.Host)
+	assert.Equal(t, "test_grpc", spiffeID.Service)
+}
+
+func TestExecuteFileQueryReturnsEntriesFromJSON(t *testing.T) {
+	fq, path := setupFileQueryTests(t, testJSONFile)
+	defer os.Remove(path)
+
+	entries, err := fq.Execute("test_grpc", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "localhost:8081", entries[1].Addr)
+	assert.Equal(t, 2, entries[1].Weight)
+}
+
+func TestExecuteFileQueryReturnsNoEntriesForUnknownService(t *testing.T) {
+	fq, path := setupFileQueryTests(t, testHCLFile)
+	defer os.Remove(path)
+
+	entries, err := fq.Execute("unknown", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestExecuteFileQueryReturnsErrorWhenNoFile(t *testing.T) {
+	fq := NewFileQuery("/does/not/exist.hcl")
+
+	_, err := fq.Execute("test_grpc", nil)
+
+	assert.Error(t, err)
+}
+
+func TestExecuteFileQueryReloadsChangedFile(t *testing.T) {
+	fq, path := setupFileQueryTests(t, testHCLFile)
+	defer os.Remove(path)
+	fq.Execute("test_grpc", nil)
+
+	writeTestFile(path, `service "test_grpc" { address = "localhost:9090" }`)
+	entries, err := fq.Execute("test_grpc", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "localhost:9090", entries[0].Addr)
+}
+
+func TestExecuteFileQueryReturnsPreviousEntriesWhenFileInvalid(t *testing.T) {
+	fq, path := setupFileQueryTests(t, testHCLFile)
+	defer os.Remove(path)
+	fq.Execute("test_grpc", nil)
+
+	writeTestFile(path, `service "test_grpc" {`)
+	entries, err := fq.Execute("test_grpc", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestExecuteFileQueryLogsReloadFailure(t *testing.T) {
+	fq, path := setupFileQueryTests(t, testHCLFile)
+	defer os.Remove(path)
+	logger := NewMockLogger()
+	fq.Logger = logger
+	fq.Execute("test_grpc", nil)
+
+	sr := setupTracing(t)
+	writeTestFile(path, `service "test_grpc" {`)
+	fq.Execute("test_grpc", nil)
+
+	logger.AssertCalled(t, "Warn", "unable to reload file, using previous endpoints", mock.Anything)
+
+	spans := sr.Ended()
+	assert.Len(t, spans, 1)
+	assert.Len(t, spans[0].Events(), 1)
+	assert.Equal(t, "exception", spans[0].Events()[0].Name)
+}
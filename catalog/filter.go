@@ -0,0 +1,272 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// filter is a parsed subset filter expression, it supports the subset of the
+// Consul filtering language which is commonly used in service-resolver
+// subsets. Expressions are made of matches joined by and / or, and binds
+// tighter than or, parentheses are not supported.
+//
+// The supported matches are:
+//
+//	<Selector> == <Value>
+//	<Selector> != <Value>
+//	<Value> in <Selector>
+//	<Value> not in <Selector>
+//	<Selector> contains <Value>
+//	<Selector> not contains <Value>
+//	<Selector> is empty
+//	<Selector> is not empty
+//
+// The supported selectors are Service.ID, Service.Service, Service.Tags,
+// Service.Meta, Service.Meta.<key>, Node.Node, Node.Address, Node.Datacenter,
+// Node.Meta and Node.Meta.<key>.
+// See https://www.consul.io/api/features/filtering.html
+type filter struct {
+	// or contains groups of matches which are joined with and
+	or [][]filterMatch
+}
+
+type filterMatch struct {
+	selector string
+	operator string
+	value    string
+	negate   bool
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+// parseFilter parses the expression, an empty expression matches every
+// service instance
+func parseFilter(expression string) (*filter, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &filter{}
+	if len(tokens) == 0 {
+		return f, nil
+	}
+
+	and := make([]filterMatch, 0)
+	start := 0
+
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && (tokens[i].quoted || (tokens[i].text != "and" && tokens[i].text != "or")) {
+			continue
+		}
+
+		m, err := parseFilterMatch(tokens[start:i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter %q: %s", expression, err)
+		}
+
+		and = append(and, m)
+		start = i + 1
+
+		if i == len(tokens) || tokens[i].text == "or" {
+			f.or = append(f.or, and)
+			and = make([]filterMatch, 0)
+		}
+	}
+
+	return f, nil
+}
+
+// parseFilterMatch parses a single match from the tokens between and / or
+func parseFilterMatch(t []filterToken) (filterMatch, error) {
+	text := make([]string, len(t))
+	for i := range t {
+		text[i] = t[i].text
+		if t[i].quoted {
+			// quoted values can not be mistaken for an operator
+			text[i] = "\"" + text[i]
+		}
+	}
+
+	var m filterMatch
+
+	switch {
+	case len(t) == 3 && (text[1] == "==" || text[1] == "!="):
+		m = filterMatch{selector: t[0].text, operator: "==", value: t[2].text, negate: text[1] == "!="}
+	case len(t) == 3 && text[1] == "in":
+		m = filterMatch{selector: t[2].text, operator: "contains", value: t[0].text}
+	case len(t) == 4 && text[1] == "not" && text[2] == "in":
+		m = filterMatch{selector: t[3].text, operator: "contains", value: t[0].text, negate: true}
+	case len(t) == 3 && text[1] == "contains":
+		m = filterMatch{selector: t[0].text, operator: "contains", value: t[2].text}
+	case len(t) == 4 && text[1] == "not" && text[2] == "contains":
+		m = filterMatch{selector: t[0].text, operator: "contains", value: t[3].text, negate: true}
+	case len(t) == 3 && text[1] == "is" && text[2] == "empty":
+		m = filterMatch{selector: t[0].text, operator: "empty"}
+	case len(t) == 4 && text[1] == "is" && text[2] == "not" && text[3] == "empty":
+		m = filterMatch{selector: t[0].text, operator: "empty", negate: true}
+	default:
+		return m, fmt.Errorf("unsupported expression %q", strings.Join(text, " "))
+	}
+
+	if _, _, ok := selectFilterValue(m.selector, &api.ServiceEntry{Service: &api.AgentService{}, Node: &api.Node{}}); !ok {
+		return m, fmt.Errorf("unsupported selector %q", m.selector)
+	}
+
+	return m, nil
+}
+
+// tokenizeFilter splits the expression into tokens, values can be quoted
+// with double quotes or backticks
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	tokens := make([]filterToken, 0)
+	current := ""
+
+	flush := func() {
+		if current != "" {
+			tokens = append(tokens, filterToken{text: current})
+			current = ""
+		}
+	}
+
+	for i := 0; i < len(expression); i++ {
+		c := expression[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '"' || c == '`':
+			flush()
+
+			end := strings.IndexByte(expression[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid filter %q: unterminated string", expression)
+			}
+
+			tokens = append(tokens, filterToken{text: expression[i+1 : i+1+end], quoted: true})
+			i += end + 1
+		case (c == '=' || c == '!') && i+1 < len(expression) && expression[i+1] == '=':
+			flush()
+			tokens = append(tokens, filterToken{text: expression[i : i+2]})
+			i++
+		case c == '(' || c == ')':
+			return nil, fmt.Errorf("Invalid filter %q: parentheses are not supported", expression)
+		default:
+			current += string(c)
+		}
+	}
+
+	flush()
+
+	return tokens, nil
+}
+
+// matches returns true when the service instance matches the filter
+func (f *filter) matches(se *api.ServiceEntry) bool {
+	if len(f.or) == 0 {
+		return true
+	}
+
+	for _, and := range f.or {
+		matched := true
+
+		for _, m := range and {
+			if !m.matches(se) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m filterMatch) matches(se *api.ServiceEntry) bool {
+	value, collection, _ := selectFilterValue(m.selector, se)
+
+	var result bool
+
+	switch m.operator {
+	case "==":
+		result = len(value) == 1 && value[0] == m.value && collection == nil
+	case "contains":
+		if collection != nil {
+			for _, v := range collection {
+				if v == m.value {
+					result = true
+				}
+			}
+		} else {
+			result = len(value) == 1 && strings.Contains(value[0], m.value)
+		}
+	case "empty":
+		result = len(collection) == 0 && (len(value) == 0 || value[0] == "")
+	}
+
+	return result != m.negate
+}
+
+// selectFilterValue returns the value of the selector for the service
+// instance, value contains a single element for a string field and
+// collection the elements of a list or the keys of a map, false is returned
+// when the selector is not supported
+func selectFilterValue(selector string, se *api.ServiceEntry) (value []string, collection []string, ok bool) {
+	parts := strings.SplitN(selector, ".", 3)
+	if len(parts) < 2 {
+		return nil, nil, false
+	}
+
+	var meta map[string]string
+
+	switch parts[0] + "." + parts[1] {
+	case "Service.ID":
+		value = []string{se.Service.ID}
+	case "Service.Service":
+		value = []string{se.Service.Service}
+	case "Service.Tags":
+		collection = append([]string{}, se.Service.Tags...)
+	case "Service.Meta":
+		meta = se.Service.Meta
+	case "Node.Node":
+		value = []string{se.Node.Node}
+	case "Node.Address":
+		value = []string{se.Node.Address}
+	case "Node.Datacenter":
+		value = []string{se.Node.Datacenter}
+	case "Node.Meta":
+		meta = se.Node.Meta
+	default:
+		return nil, nil, false
+	}
+
+	if len(parts) == 3 {
+		if parts[1] != "Meta" {
+			return nil, nil, false
+		}
+
+		v, found := meta[parts[2]]
+		if !found {
+			return nil, nil, true
+		}
+
+		return []string{v}, nil, true
+	}
+
+	if parts[1] == "Meta" {
+		collection = make([]string, 0, len(meta))
+		for k := range meta {
+			collection = append(collection, k)
+		}
+	}
+
+	return value, collection, true
+}
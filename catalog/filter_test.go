@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func testFilterEntry() *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Service: &api.AgentService{
+			ID:      "web-1",
+			Service: "web",
+			Tags:    []string{"canary", "http"},
+			Meta:    map[string]string{"version": "v2"},
+		},
+		Node: &api.Node{
+			Node:       "node1",
+			Address:    "10.0.0.1",
+			Datacenter: "dc1",
+			Meta:       map[string]string{"zone": "a"},
+		},
+	}
+}
+
+func TestFilterMatchesExpressions(t *testing.T) {
+	tests := map[string]bool{
+		"":                                          true,
+		"Service.Meta.version == v2":                true,
+		"Service.Meta.version==v2":                  true,
+		`Service.Meta.version == "v1"`:              false,
+		"Service.Meta.version != v1":                true,
+		"canary in Service.Tags":                    true,
+		"grpc in Service.Tags":                      false,
+		"grpc not in Service.Tags":                  true,
+		"Service.Tags contains http":                true,
+		"Service.Tags not contains http":            false,
+		"version in Service.Meta":                   true,
+		"Node.Meta.zone == a":                       true,
+		"Node.Meta.rack is empty":                   true,
+		"Node.Meta.zone is not empty":               true,
+		"Node.Datacenter == dc1":                    true,
+		"Service.ID == web-1 and Node.Node == x":    false,
+		"Service.ID == web-2 or Node.Node == node1": true,
+		"Service.ID == x or Node.Node == node1 and Node.Address == 10.0.0.2": false,
+		"Service.Service contains we":                                        true,
+		`"and" in Service.Tags`:                                              false,
+	}
+
+	for expression, expected := range tests {
+		f, err := parseFilter(expression)
+
+		assert.NoError(t, err, expression)
+		assert.Equal(t, expected, f.matches(testFilterEntry()), expression)
+	}
+}
+
+func TestParseFilterReturnsErrorForInvalidExpressions(t *testing.T) {
+	tests := []string{
+		"Service.Unknown == x",
+		"Service.Meta.version =",
+		"(Service.ID == web-1)",
+		`Service.ID == "web-1`,
+		"Service.ID == web-1 and",
+	}
+
+	for _, expression := range tests {
+		_, err := parseFilter(expression)
+
+		assert.Error(t, err, expression)
+	}
+}
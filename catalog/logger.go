@@ -0,0 +1,33 @@
+package catalog
+
+// Logger is a structured logger used to report resolver events, args are
+// alternating key and value pairs. The interface is satisfied by hclog.Logger
+// and *slog.Logger so either can be used without an adapter.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NewNopLogger returns a Logger which discards all messages, this is the
+// default when no Logger has been set
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// LoggerOrNop returns l or a Logger which discards all messages when l is nil
+func LoggerOrNop(l Logger) Logger {
+	if l == nil {
+		return nopLogger{}
+	}
+
+	return l
+}
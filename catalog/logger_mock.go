@@ -0,0 +1,37 @@
+package catalog
+
+import (
+	"github.com/stretchr/testify/mock"
+)
+
+// MockLogger is a mock implementation of Logger for use in tests, the key and
+// value pairs are passed to the mock as a single []interface{} argument
+type MockLogger struct {
+	mock.Mock
+}
+
+// NewMockLogger returns a MockLogger which accepts all messages
+func NewMockLogger() *MockLogger {
+	m := &MockLogger{}
+	for _, level := range []string{"Debug", "Info", "Warn", "Error"} {
+		m.On(level, mock.Anything, mock.Anything)
+	}
+
+	return m
+}
+
+func (m *MockLogger) Debug(msg string, args ...interface{}) {
+	m.Called(msg, args)
+}
+
+func (m *MockLogger) Info(msg string, args ...interface{}) {
+	m.Called(msg, args)
+}
+
+func (m *MockLogger) Warn(msg string, args ...interface{}) {
+	m.Called(msg, args)
+}
+
+func (m *MockLogger) Error(msg string, args ...interface{}) {
+	m.Called(msg, args)
+}
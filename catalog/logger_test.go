@@ -0,0 +1,27 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNopLoggerDiscardsMessages(t *testing.T) {
+	l := NewNopLogger()
+
+	assert.NotPanics(t, func() { l.Error("query failed", "error", "boom") })
+}
+
+func TestLoggerOrNopReturnsNopLoggerWhenNil(t *testing.T) {
+	l := LoggerOrNop(nil)
+
+	assert.Equal(t, NewNopLogger(), l)
+}
+
+func TestLoggerOrNopReturnsLogger(t *testing.T) {
+	ml := NewMockLogger()
+
+	LoggerOrNop(ml).Warn("connect resolution miss", "address", "10.0.0.1:8080")
+
+	ml.AssertCalled(t, "Warn", "connect resolution miss", []interface{}{"address", "10.0.0.1:8080"})
+}
@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/hashicorp/consul/api"
+)
+
+// MeshGatewayMode defines how Connect services in a remote datacenter are
+// reached
+type MeshGatewayMode int
+
+const (
+	// MeshGatewayModeNone returns the addresses of the proxies in the remote
+	// datacenter, these must be routable from the local network
+	MeshGatewayModeNone MeshGatewayMode = iota
+	// MeshGatewayModeLocal returns the addresses of the mesh gateways in the
+	// local datacenter
+	MeshGatewayModeLocal
+	// MeshGatewayModeRemote returns the WAN addresses of the mesh gateways in
+	// the remote datacenter
+	MeshGatewayModeRemote
+)
+
+// isRemote returns true when the query options target a datacenter other than
+// the datacenter of the local agent
+func (s *ServiceQuery) isRemote(options *api.QueryOptions) (bool, error) {
+	if options.Datacenter == "" {
+		return false, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.localDatacenter == "" {
+		self, err := s.agent.Self()
+		if err != nil {
+			return false, err
+		}
+
+		dc, ok := self["Config"]["Datacenter"].(string)
+		if !ok {
+			return false, fmt.Errorf("Unable to determine the local datacenter")
+		}
+
+		s.localDatacenter = dc
+	}
+
+	return options.Datacenter != s.localDatacenter, nil
+}
+
+// executeMeshGateway returns the addresses of the mesh gateways which can
+// route to the service in the remote datacenter, each entry contains the
+// CertURI of the destination service and the SNI the gateway uses to route
+// the connection. The instances of the destination service are filtered and
+// checked against the panic threshold in the same way as a local query.
+func (s *ServiceQuery) executeMeshGateway(ctx context.Context, e *execution, name string, options *api.QueryOptions, passingOnly bool, filter *filter) ([]ServiceEntry, error) {
+	ses := make([]ServiceEntry, 0)
+
+	// only route to the gateways when there are instances of the destination
+	// service which can receive traffic
+	services, meta, err := s.client.Connect(name, "", s.queryPassingOnly(passingOnly), options)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil {
+		e.meta = meta
+	}
+
+	var certURI connect.CertURI
+	if cs := s.candidates(e, services, passingOnly, filter); len(cs) > 0 {
+		certURI, err = s.buildCert(ctx, cs[0].entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if certURI == nil {
+		return ses, nil
+	}
+
+	gwOptions := *options
+	if s.MeshGateway == MeshGatewayModeLocal {
+		gwOptions.Datacenter = ""
+	}
+
+	gateways, _, err := s.client.Service(s.MeshGatewayService, "", true, &gwOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	spiffeID := certURI.(*connect.SpiffeIDService)
+	sni := fmt.Sprintf(
+		"%s.%s.%s.internal.%s",
+		spiffeID.Service,
+		spiffeID.Namespace,
+		spiffeID.Datacenter,
+		spiffeID.Host,
+	)
+
+	for _, gw := range gateways {
+		se := ServiceEntry{
+			Addr:    s.buildGatewayAddress(gw, name),
+			CertURI: certURI,
+			Weight:  buildWeight(gw),
+			SNI:     sni,
+		}
+
+		if se.Weight == 0 {
+			continue
+		}
+
+		ses = append(ses, se)
+	}
+
+	return ses, nil
+}
+
+// buildGatewayAddress returns the address for the gateway, gateways in a
+// remote datacenter are reached using the WAN address of the node
+func (s *ServiceQuery) buildGatewayAddress(gw *api.ServiceEntry, name string) string {
+	if s.MeshGateway == MeshGatewayModeRemote {
+		return buildAddress(gw, AddressWAN)
+	}
+
+	return buildAddress(gw, s.policy(name))
+}
@@ -0,0 +1,158 @@
+package catalog
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var gateways []*api.ServiceEntry
+
+func testGetGateways() []*api.ServiceEntry {
+	return gateways
+}
+
+func setupMeshGatewayTests(t *testing.T, mode MeshGatewayMode) *ServiceQuery {
+	sq := setupServiceQueryTests(t, true)
+	sq.MeshGateway = mode
+	sq.MeshGatewayService = "mesh-gateway"
+
+	ses[0].Node.Datacenter = "dc2"
+	ses[0].Service.Proxy = &api.AgentServiceConnectProxyConfig{DestinationServiceName: "web"}
+
+	gateways = []*api.ServiceEntry{
+		&api.ServiceEntry{
+			Service: &api.AgentService{Service: "mesh-gateway", Address: "10.0.0.1", Port: 8443},
+			Node: &api.Node{
+				Address:         "10.0.0.1",
+				TaggedAddresses: map[string]string{"wan": "198.51.100.1"},
+			},
+		},
+	}
+
+	agentMock.On("Self").Return(map[string]map[string]interface{}{
+		"Config": map[string]interface{}{"Datacenter": "dc1"},
+	}, nil)
+
+	healthMock.ExpectedCalls = make([]*mock.Call, 0)
+	healthMock.On("Connect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(testGetServices, nil, nil)
+	healthMock.On("Service", "mesh-gateway", mock.Anything, mock.Anything, mock.Anything).Return(testGetGateways, nil, nil)
+
+	return sq
+}
+
+func TestExecuteMeshGatewayRemoteReturnsGatewayWANAddress(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeRemote)
+
+	entries, err := sq.Execute("web", &api.QueryOptions{Datacenter: "dc2"})
+
+	assert.NoError(t, err)
+	healthMock.AssertCalled(t, "Service", "mesh-gateway", "", true, &api.QueryOptions{Datacenter: "dc2"})
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "198.51.100.1:8443", entries[0].Addr)
+	assert.Equal(t, "web.default.dc2.internal.abc.com", entries[0].SNI)
+
+	spiffeID := entries[0].CertURI.(*connect.SpiffeIDService)
+	assert.Equal(t, "dc2", spiffeID.Datacenter)
+	assert.Equal(t, "web", spiffeID.Service)
+}
+
+func TestExecuteMeshGatewayLocalReturnsLocalGatewayAddress(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeLocal)
+
+	entries, err := sq.Execute("web", &api.QueryOptions{Datacenter: "dc2"})
+
+	assert.NoError(t, err)
+	healthMock.AssertCalled(t, "Service", "mesh-gateway", "", true, &api.QueryOptions{})
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "10.0.0.1:8443", entries[0].Addr)
+	assert.Equal(t, "web.default.dc2.internal.abc.com", entries[0].SNI)
+}
+
+func TestExecuteMeshGatewayReturnsNoEntriesWhenNoDestinations(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeRemote)
+	ses = make([]*api.ServiceEntry, 0)
+
+	entries, err := sq.Execute("web", &api.QueryOptions{Datacenter: "dc2"})
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+	healthMock.AssertNotCalled(t, "Service", "mesh-gateway", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestExecuteMeshGatewayReturnsProxiesForLocalDatacenter(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeRemote)
+
+	entries, err := sq.Execute("web", &api.QueryOptions{Datacenter: "dc1"})
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "localhost:8080", entries[0].Addr)
+	assert.Equal(t, "", entries[0].SNI)
+}
+
+func TestExecuteMeshGatewayUsesQueryDatacenter(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeRemote)
+	sq.Datacenter = "dc2"
+
+	entries, err := sq.Execute("web", nil)
+
+	assert.NoError(t, err)
+	healthMock.AssertCalled(t, "Connect", "web", "", true, &api.QueryOptions{Datacenter: "dc2"})
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "198.51.100.1:8443", entries[0].Addr)
+}
+
+func TestExecuteMeshGatewayAppliesSubsetFilter(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeRemote)
+	ses[0].Service.Tags = []string{"v1"}
+	f, _ := parseFilter(`"v2" in Service.Tags`)
+
+	entries, err := sq.execute(context.Background(), &execution{}, "web", &api.QueryOptions{Datacenter: "dc2"}, true, f)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+	healthMock.AssertNotCalled(t, "Service", "mesh-gateway", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestExecuteMeshGatewayAppliesPanicThreshold(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeRemote)
+	sq.PanicThreshold = 0.5
+	ses[0].Checks = api.HealthChecks{&api.HealthCheck{Status: api.HealthCritical}}
+
+	entries, err := sq.Execute("web", &api.QueryOptions{Datacenter: "dc2"})
+
+	assert.NoError(t, err)
+	healthMock.AssertCalled(t, "Connect", "web", "", false, &api.QueryOptions{Datacenter: "dc2"})
+	assert.Len(t, entries, 1)
+	assert.True(t, sq.InPanic("web"))
+}
+
+func TestExecuteMeshGatewayCanBeCalledConcurrently(t *testing.T) {
+	sq := setupMeshGatewayTests(t, MeshGatewayModeRemote)
+
+	// delay the agent so that the queries overlap
+	for _, c := range agentMock.ExpectedCalls {
+		c.After(10 * time.Millisecond)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sq.Execute("web", &api.QueryOptions{Datacenter: "dc2"})
+		}()
+	}
+
+	wg.Wait()
+
+	agentMock.AssertNumberOfCalls(t, "Self", 1)
+	agentMock.AssertNumberOfCalls(t, "ConnectCARoots", 1)
+}
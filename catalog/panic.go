@@ -0,0 +1,67 @@
+package catalog
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// PanicReporter is implemented by queries which return all registered
+// instances when too few instances are healthy
+type PanicReporter interface {
+	InPanic(name string) bool
+}
+
+// panicStore records whether each query name is in panic mode
+type panicStore struct {
+	mutex     sync.Mutex
+	panicking map[string]bool
+}
+
+// InPanic returns true when the last execution of the query with the given
+// name returned all registered instances because too few were healthy
+func (p *panicStore) InPanic(name string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.panicking[name]
+}
+
+// setPanic records the panic mode for the query name and returns true when
+// the mode has changed
+func (p *panicStore) setPanic(name string, inPanic bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.panicking == nil {
+		p.panicking = make(map[string]bool)
+	}
+
+	changed := p.panicking[name] != inPanic
+	p.panicking[name] = inPanic
+
+	return changed
+}
+
+// panicWeight returns the weight for an unhealthy instance returned in panic
+// mode, the instance receives the weight of a passing instance
+func panicWeight(se *api.ServiceEntry) int {
+	if se.Service.Weights.Passing > 0 {
+		return se.Service.Weights.Passing
+	}
+
+	return 1
+}
+
+// inMaintenance returns true when the instance or its node has been put into
+// maintenance, these instances are never returned even in panic mode
+func inMaintenance(se *api.ServiceEntry) bool {
+	for _, c := range se.Checks {
+		if c.CheckID == api.NodeMaint || strings.HasPrefix(c.CheckID, api.ServiceMaintPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
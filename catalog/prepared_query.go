@@ -1,25 +1,59 @@
 package catalog
 
-import "github.com/hashicorp/consul/api"
+import (
+	"context"
 
+	"github.com/hashicorp/consul/api"
+)
+
+// PreparedQuery resolves a target by executing the Consul prepared query with
+// the name of the target. Consul removes critical instances from the result
+// of a prepared query on the servers, the registered instances are not known
+// so PanicThreshold is not supported.
 type PreparedQuery struct {
 	client ConsulPreparedQuery
+
+	// Datacenter to query, when empty the datacenter of the local agent is used
+	Datacenter string
+
+	Addressing
+	Cache
+	metaStore
 }
 
 func NewPreparedQuery(client ConsulPreparedQuery) *PreparedQuery {
-	return &PreparedQuery{client}
+	return &PreparedQuery{client: client}
 }
 
-func (s *PreparedQuery) Execute(name string, options *api.QueryOptions) ([]ServiceEntry, error) {
-	pqr, _, err := s.client.Execute(name, options)
+func (s *PreparedQuery) Execute(name string, options *api.QueryOptions) (ses []ServiceEntry, err error) {
+	_, span := startSpan(context.Background(), "catalog.PreparedQuery.Execute", name)
+	defer func() { endSpan(span, ses, err) }()
+
+	options = s.queryOptions(options)
+	if options.Datacenter == "" {
+		options.Datacenter = s.Datacenter
+	}
+
+	pqr, meta, err := s.client.Execute(name, options)
 	if err != nil {
 		return nil, err
 	}
 
-	ses := make([]ServiceEntry, 0)
+	s.setMeta(name, meta)
+	setDatacenter(span, pqr.Datacenter)
+
+	ses = make([]ServiceEntry, 0)
 	for _, se := range pqr.Nodes {
 		s := ServiceEntry{
-			Addr: buildAddress(&se),
+			Addr:   buildAddress(&se, s.policy(name)),
+			Weight: buildWeight(&se),
+			Tags:   se.Service.Tags,
+		}
+
+		// the query may return instances in the warning state which have
+		// been configured to receive no traffic
+		if s.Weight == 0 {
+			continue
 		}
 
 		ses = append(ses, s)
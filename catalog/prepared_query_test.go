@@ -2,6 +2,7 @@ package catalog
 
 import (
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
@@ -55,3 +56,45 @@ func TestExecutePreparedQueryReturnsEntriesWhenNodeAddress(t *testing.T) {
 	assert.Len(t, entries, 1)
 	assert.Equal(t, "node:8080", entries[0].Addr)
 }
+
+func TestExecutePreparedQueryIgnoresWarningEntriesWithNoWeight(t *testing.T) {
+	sq := setupPreparedQueryTests(t)
+	srs.Nodes[0].Service.Weights = api.AgentWeights{Passing: 1, Warning: 0}
+	srs.Nodes[0].Checks = api.HealthChecks{&api.HealthCheck{Status: api.HealthWarning}}
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestExecutePreparedQueryUsesAgentCache(t *testing.T) {
+	sq := setupPreparedQueryTests(t)
+	sq.UseCache = true
+	sq.MaxAge = 10 * time.Second
+
+	_, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	queryMock.AssertCalled(t, "Execute", "localhost", &api.QueryOptions{UseCache: true, MaxAge: 10 * time.Second})
+}
+
+func TestExecutePreparedQueryUsesDatacenter(t *testing.T) {
+	sq := setupPreparedQueryTests(t)
+	sq.Datacenter = "dc2"
+
+	_, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	queryMock.AssertCalled(t, "Execute", "localhost", &api.QueryOptions{Datacenter: "dc2"})
+}
+
+func TestExecutePreparedQueryPrefersDatacenterFromOptions(t *testing.T) {
+	sq := setupPreparedQueryTests(t)
+	sq.Datacenter = "dc2"
+
+	_, err := sq.Execute("localhost", &api.QueryOptions{Datacenter: "dc3"})
+
+	assert.NoError(t, err)
+	queryMock.AssertCalled(t, "Execute", "localhost", &api.QueryOptions{Datacenter: "dc3"})
+}
@@ -1,8 +1,6 @@
 package catalog
 
 import (
-	"fmt"
-
 	"github.com/hashicorp/consul/agent/connect"
 	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/mock"
@@ -10,9 +8,17 @@ import (
 
 // ServiceEntry describes the details for service resolution, CertURI will be
 // null unless the Service is a Consul Connect service.
+// Weight is derived from the Consul service weights for the current health
+// state of the service.
+// SNI is only set when Addr is a mesh gateway, the TLS connection must use
+// the SNI so that the gateway can route to the destination service.
+// Tags contains the Consul tags for the service instance.
 type ServiceEntry struct {
 	Addr    string
 	CertURI connect.CertURI
+	Weight  int
+	SNI     string
+	Tags    []string
 }
 
 // Query defines an interface for service discovery methods to implement,
@@ -38,11 +44,23 @@ func (m *MockQuery) Execute(name string, options *api.QueryOptions) ([]ServiceEn
 	return nil, args.Error(1)
 }
 
-// helper function to build the address for the upstream service
-func buildAddress(se *api.ServiceEntry) string {
-	if se.Service.Address != "" {
-		return fmt.Sprintf("%s:%d", se.Service.Address, se.Service.Port)
+// helper function to determine the weight for the upstream service from the
+// Consul weights for its current health state, a weight of 0 means the service
+// should not receive any traffic
+func buildWeight(se *api.ServiceEntry) int {
+	w := se.Service.Weights
+
+	// weights are not returned by older agents, use the Consul defaults
+	if w.Passing == 0 && w.Warning == 0 {
+		w = api.AgentWeights{Passing: 1, Warning: 1}
+	}
+
+	switch se.Checks.AggregatedStatus() {
+	case api.HealthPassing:
+		return w.Passing
+	case api.HealthWarning:
+		return w.Warning
 	}
 
-	return fmt.Sprintf("%s:%d", se.Node.Address, se.Service.Port)
+	return 0
 }
@@ -1,10 +1,16 @@
 package catalog
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/hashicorp/consul/agent/connect"
 	"github.com/hashicorp/consul/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ServiceQuery implements the logic to lookup a service in Consul's Service Catalog
@@ -13,42 +19,155 @@ type ServiceQuery struct {
 	agent       ConsulAgent
 	useConnect  bool // should we query the
 	trustDomain string
+
+	// mutex guards trustDomain and localDatacenter which are fetched when
+	// first needed, the query is shared by the watchers of every target
+	mutex sync.Mutex
+
+	// PassingOnly restricts the query to instances where all health checks are
+	// passing, when false instances in the warning state are also returned.
+	// Instances in the critical state are never returned.
+	PassingOnly bool
+
+	// PanicThreshold is the fraction of registered instances which must be
+	// healthy, when fewer instances are healthy all registered instances are
+	// returned so that the healthy instances are not overloaded. Instances in
+	// maintenance are not counted and are never returned. When 0 only healthy
+	// instances are returned. The threshold also applies to Connect queries
+	// and to the DiscoveryChainQuery which embeds the ServiceQuery.
+	PanicThreshold float64
+
+	// Datacenter to query, when empty the datacenter of the local agent is used
+	Datacenter string
+
+	// MeshGateway defines how Connect services in other datacenters are
+	// reached, defaults to MeshGatewayModeNone
+	MeshGateway MeshGatewayMode
+	// MeshGatewayService is the name of the mesh gateway service, defaults to
+	// mesh-gateway
+	MeshGatewayService string
+	localDatacenter    string
+
+	// Logger reports the trust domain fetches, defaults to a Logger which
+	// discards all messages
+	Logger Logger
+
+	Addressing
+	Cache
+	metaStore
+	panicStore
 }
 
 // NewServiceQuery creates a new ServiceQuery struct configured with a Consul API
 // Client
 // Setting the useConnect parameter to true will query the Consul Connect service
 // catalog and return the address to the Connect proxy associated with the service
+// PassingOnly is set to a default of true
 func NewServiceQuery(client *api.Client, useConnect bool) *ServiceQuery {
-	return &ServiceQuery{client.Health(), client.Agent(), useConnect, ""}
+	return &ServiceQuery{
+		client:      client.Health(),
+		agent:       client.Agent(),
+		useConnect:  useConnect,
+		PassingOnly: true,
+
+		MeshGatewayService: "mesh-gateway",
+	}
 }
 
 // Execute the query against the API and build a list of ServiceEntry structs
 // which can be used by the resolver
-func (s *ServiceQuery) Execute(name string, options *api.QueryOptions) ([]ServiceEntry, error) {
+func (s *ServiceQuery) Execute(name string, options *api.QueryOptions) (ses []ServiceEntry, err error) {
+	ctx, span := startSpan(context.Background(), "catalog.ServiceQuery.Execute", name)
+	defer func() { endSpan(span, ses, err) }()
+
+	e := &execution{}
+	ses, err = s.execute(ctx, e, name, options, s.PassingOnly, nil)
+	if err == nil {
+		s.record(name, e)
+	}
+
+	return ses, err
+}
+
+// execution collects the QueryMeta and panic state of the Consul queries made
+// to resolve a target, a target which is split or redirected by the discovery
+// chain is resolved with more than one query
+type execution struct {
+	meta    *api.QueryMeta
+	inPanic bool
+	healthy int
+	total   int
+}
+
+// record stores the QueryMeta and panic state of the execution under the name
+// of the target, a change of panic mode is logged
+func (s *ServiceQuery) record(target string, e *execution) {
+	s.setMeta(target, e.meta)
+
+	if !s.setPanic(target, e.inPanic) {
+		return
+	}
+
+	if e.inPanic {
+		LoggerOrNop(s.Logger).Warn("panic threshold reached, returning all instances", "service", target, "healthy", e.healthy, "total", e.total)
+	} else {
+		LoggerOrNop(s.Logger).Info("panic threshold recovered", "service", target, "healthy", e.healthy, "total", e.total)
+	}
+}
+
+// execute runs the query recording the QueryMeta and panic state in e, when
+// filter is not nil only the instances which match the filter are returned
+func (s *ServiceQuery) execute(ctx context.Context, e *execution, name string, options *api.QueryOptions, passingOnly bool, filter *filter) ([]ServiceEntry, error) {
 	ses := make([]ServiceEntry, 0)
 
 	var services []*api.ServiceEntry
+	var meta *api.QueryMeta
 	var err error
 
+	options = s.queryOptions(options)
+	if options.Datacenter == "" {
+		options.Datacenter = s.Datacenter
+	}
+
+	setDatacenter(trace.SpanFromContext(ctx), options.Datacenter)
+
+	if s.useConnect && s.MeshGateway != MeshGatewayModeNone {
+		remote, err := s.isRemote(options)
+		if err != nil {
+			return nil, err
+		}
+
+		if remote {
+			return s.executeMeshGateway(ctx, e, name, options, passingOnly, filter)
+		}
+	}
+
+	queryPassingOnly := s.queryPassingOnly(passingOnly)
+
 	// Are we looking up the service in the standard service catalog or the connect
 	// service catalog
 	if s.useConnect {
-		services, _, err = s.client.Connect(name, "", true, options)
+		services, meta, err = s.client.Connect(name, "", queryPassingOnly, options)
 	} else {
-		services, _, err = s.client.Service(name, "", true, options)
+		services, meta, err = s.client.Service(name, "", queryPassingOnly, options)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	for _, svc := range services {
+	if meta != nil {
+		e.meta = meta
+	}
+
+	for _, c := range s.candidates(e, services, passingOnly, filter) {
 		se := ServiceEntry{}
-		se.Addr = buildAddress(svc)
+		se.Addr = buildAddress(c.entry, s.policy(name))
+		se.Weight = c.weight
+		se.Tags = c.entry.Service.Tags
 
 		if s.useConnect {
-			certURI, err := s.buildCert(svc)
+			certURI, err := s.buildCert(ctx, c.entry)
 			if err != nil {
 				return nil, err
 			}
@@ -62,8 +181,98 @@ func (s *ServiceQuery) Execute(name string, options *api.QueryOptions) ([]Servic
 	return ses, nil
 }
 
-func (s *ServiceQuery) buildCert(se *api.ServiceEntry) (connect.CertURI, error) {
-	service := se.Service.Proxy.DestinationServiceName
+// queryPassingOnly returns true when the health of the instances can be
+// filtered by Consul, the panic threshold is calculated from all registered
+// instances so when it is set the health is filtered after the query
+func (s *ServiceQuery) queryPassingOnly(passingOnly bool) bool {
+	return passingOnly && s.PanicThreshold <= 0
+}
+
+// candidate is a service instance which can receive traffic
+type candidate struct {
+	entry  *api.ServiceEntry
+	weight int
+}
+
+// candidates returns the instances from the query result which match the
+// filter and are healthy, when the query is in panic mode the unhealthy
+// instances are also returned with the weight of a passing instance
+func (s *ServiceQuery) candidates(e *execution, services []*api.ServiceEntry, passingOnly bool, filter *filter) []candidate {
+	// instances in maintenance are excluded from the panic threshold so that
+	// taking instances out of service does not trigger panic mode
+	matched := make([]*api.ServiceEntry, 0, len(services))
+	for _, svc := range services {
+		if inMaintenance(svc) || (filter != nil && !filter.matches(svc)) {
+			continue
+		}
+
+		matched = append(matched, svc)
+	}
+
+	services = matched
+
+	inPanic := s.checkPanic(e, services, passingOnly)
+
+	cs := make([]candidate, 0, len(services))
+	for _, svc := range services {
+		c := candidate{entry: svc, weight: buildWeight(svc)}
+
+		// critical instances and instances in the warning state with a weight
+		// of 0 should not receive traffic unless the query is in panic mode
+		if !isHealthy(svc, passingOnly) {
+			if !inPanic {
+				continue
+			}
+
+			c.weight = panicWeight(svc)
+		}
+
+		cs = append(cs, c)
+	}
+
+	return cs
+}
+
+// checkPanic returns true when the fraction of healthy instances is below the
+// panic threshold, the counts are added to the execution which is in panic
+// mode when any of its queries is
+func (s *ServiceQuery) checkPanic(e *execution, services []*api.ServiceEntry, passingOnly bool) bool {
+	if s.PanicThreshold <= 0 || len(services) == 0 {
+		return false
+	}
+
+	healthy := 0
+	for _, svc := range services {
+		if isHealthy(svc, passingOnly) {
+			healthy++
+		}
+	}
+
+	inPanic := float64(healthy)/float64(len(services)) < s.PanicThreshold
+
+	e.healthy += healthy
+	e.total += len(services)
+	e.inPanic = e.inPanic || inPanic
+
+	return inPanic
+}
+
+// isHealthy returns true when the instance should receive traffic, when
+// passingOnly is set all health checks must be passing
+func isHealthy(se *api.ServiceEntry, passingOnly bool) bool {
+	if passingOnly && se.Checks.AggregatedStatus() != api.HealthPassing {
+		return false
+	}
+
+	return buildWeight(se) > 0
+}
+
+func (s *ServiceQuery) buildCert(ctx context.Context, se *api.ServiceEntry) (connect.CertURI, error) {
+	service := se.Service.ProxyDestination
+	if se.Service.Proxy != nil {
+		service = se.Service.Proxy.DestinationServiceName
+	}
+
 	if se.Service.Connect != nil && se.Service.Connect.Native {
 		service = se.Service.Service
 	}
@@ -74,13 +283,27 @@ func (s *ServiceQuery) buildCert(se *api.ServiceEntry) (connect.CertURI, error)
 		return nil, fmt.Errorf("not a valid connect service")
 	}
 
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	// if we have not trust domain fetch it
 	if s.trustDomain == "" {
+		_, span := otel.Tracer(tracerName).Start(ctx, "catalog.ConnectCARoots")
 		r, _, err := s.agent.ConnectCARoots(nil)
 		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			span.End()
+
+			LoggerOrNop(s.Logger).Error("unable to fetch trust domain", "error", err)
 			return nil, err
 		}
 
+		span.SetAttributes(attribute.String("trust_domain", r.TrustDomain))
+		span.End()
+
+		LoggerOrNop(s.Logger).Info("fetched trust domain", "trust_domain", r.TrustDomain)
+
 		s.trustDomain = r.TrustDomain
 	}
 
@@ -1,7 +1,9 @@
 package catalog
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/agent/connect"
 	"github.com/hashicorp/consul/api"
@@ -41,7 +43,7 @@ func setupServiceQueryTests(t *testing.T, useConnect bool) *ServiceQuery {
 	healthMock.On("Service", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(testGetServices, nil, nil)
 	healthMock.On("Connect", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(testGetServices, nil, nil)
 
-	return &ServiceQuery{healthMock, agentMock, useConnect, ""}
+	return &ServiceQuery{client: healthMock, agent: agentMock, useConnect: useConnect, PassingOnly: true}
 }
 
 func TestExecuteServiceQueryReturnsEntriesWhenServiceAddress(t *testing.T) {
@@ -70,6 +72,16 @@ func TestExecuteServiceQueryReturnsEntriesWhenNodeAddress(t *testing.T) {
 	assert.Equal(t, "node:8080", entries[0].Addr)
 }
 
+func TestExecuteServiceQueryReturnsTags(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+	ses[0].Service.Tags = []string{"canary"}
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"canary"}, entries[0].Tags)
+}
+
 func TestExecuteConnectServiceQueryReturnsValidCertURINotNative(t *testing.T) {
 	sq := setupServiceQueryTests(t, true)
 
@@ -103,3 +115,223 @@ func TestExecuteConnectServiceQueryReturnsValidCertURINative(t *testing.T) {
 	assert.Equal(t, "localhost:8081", spiffeID.Service)
 	assert.Equal(t, "abc.com", spiffeID.Host)
 }
+
+func TestExecuteConnectServiceQueryLogsTrustDomainFetch(t *testing.T) {
+	sq := setupServiceQueryTests(t, true)
+	logger := NewMockLogger()
+	sq.Logger = logger
+
+	_, err := sq.Execute("localhost.service.connect", nil)
+	assert.NoError(t, err)
+
+	_, err = sq.Execute("localhost.service.connect", nil)
+	assert.NoError(t, err)
+
+	logger.AssertCalled(t, "Info", "fetched trust domain", []interface{}{"trust_domain", "abc.com"})
+	logger.AssertNumberOfCalls(t, "Info", 1)
+}
+
+func TestExecuteConnectServiceQueryLogsTrustDomainError(t *testing.T) {
+	sq := setupServiceQueryTests(t, true)
+	agentMock.ExpectedCalls = make([]*mock.Call, 0)
+	agentMock.On("ConnectCARoots", mock.Anything).Return(&api.CARootList{}, nil, fmt.Errorf("boom"))
+	logger := NewMockLogger()
+	sq.Logger = logger
+
+	_, err := sq.Execute("localhost.service.connect", nil)
+
+	assert.Error(t, err)
+	logger.AssertCalled(t, "Error", "unable to fetch trust domain", []interface{}{"error", fmt.Errorf("boom")})
+}
+
+func TestExecuteServiceQueryReturnsWarningEntriesWhenNotPassingOnly(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+	sq.PassingOnly = false
+	ses[0].Service.Weights = api.AgentWeights{Passing: 10, Warning: 2}
+	ses[0].Checks = api.HealthChecks{&api.HealthCheck{Status: api.HealthWarning}}
+
+	entries, err := sq.Execute("localhost", nil)
+
+	healthMock.AssertCalled(t, "Service", mock.Anything, mock.Anything, false, mock.Anything)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 2, entries[0].Weight)
+}
+
+func TestExecuteServiceQueryReturnsPassingWeight(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+	ses[0].Service.Weights = api.AgentWeights{Passing: 10, Warning: 2}
+	ses[0].Checks = api.HealthChecks{&api.HealthCheck{Status: api.HealthPassing}}
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 10, entries[0].Weight)
+}
+
+func TestExecuteServiceQueryReturnsDefaultWeightWhenNoWeights(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Weight)
+}
+
+func TestExecuteServiceQueryIgnoresCriticalEntries(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+	sq.PassingOnly = false
+	ses[0].Checks = api.HealthChecks{&api.HealthCheck{Status: api.HealthCritical}}
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestExecuteServiceQueryUsesAgentCache(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+	sq.UseCache = true
+	sq.MaxAge = 10 * time.Second
+	sq.StaleIfError = 60 * time.Second
+
+	_, err := sq.Execute("localhost", &api.QueryOptions{Datacenter: "dc2"})
+
+	assert.NoError(t, err)
+	healthMock.AssertCalled(t, "Service", mock.Anything, mock.Anything, true, &api.QueryOptions{
+		Datacenter:   "dc2",
+		UseCache:     true,
+		MaxAge:       10 * time.Second,
+		StaleIfError: 60 * time.Second,
+	})
+}
+
+func TestExecuteServiceQueryRecordsQueryMeta(t *testing.T) {
+	sq := setupServiceQueryTests(t, false)
+	healthMock.ExpectedCalls = make([]*mock.Call, 0)
+	healthMock.On("Service", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(testGetServices, &api.QueryMeta{CacheHit: true, CacheAge: 2 * time.Second}, nil)
+
+	_, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Nil(t, sq.LastMeta("other"))
+	assert.True(t, sq.LastMeta("localhost").CacheHit)
+	assert.Equal(t, 2*time.Second, sq.LastMeta("localhost").CacheAge)
+}
+
+func setupPanicThresholdTests(t *testing.T, critical int) *ServiceQuery {
+	sq := setupServiceQueryTests(t, false)
+	sq.PanicThreshold = 0.5
+
+	for i := 1; i <= critical; i++ {
+		ses = append(ses, &api.ServiceEntry{
+			Service: &api.AgentService{Address: "localhost", Port: 8080 + i},
+			Node:    &api.Node{Datacenter: "dc1"},
+			Checks:  api.HealthChecks{&api.HealthCheck{CheckID: "service:localhost", Status: api.HealthCritical}},
+		})
+	}
+
+	return sq
+}
+
+func TestExecuteServiceQueryReturnsAllInstancesBelowPanicThreshold(t *testing.T) {
+	sq := setupPanicThresholdTests(t, 2)
+	logger := NewMockLogger()
+	sq.Logger = logger
+
+	entries, err := sq.Execute("localhost", nil)
+
+	healthMock.AssertCalled(t, "Service", mock.Anything, mock.Anything, false, mock.Anything)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, 1, entries[1].Weight)
+	assert.True(t, sq.InPanic("localhost"))
+	logger.AssertCalled(t, "Warn", "panic threshold reached, returning all instances", []interface{}{"service", "localhost", "healthy", 1, "total", 3})
+}
+
+func TestExecuteConnectServiceQueryReturnsAllInstancesBelowPanicThreshold(t *testing.T) {
+	sq := setupPanicThresholdTests(t, 2)
+	sq.useConnect = true
+	for _, se := range ses[1:] {
+		se.Service.ProxyDestination = "localhost:9999"
+		se.Service.Connect = &api.AgentServiceConnect{}
+	}
+
+	entries, err := sq.Execute("localhost", nil)
+
+	healthMock.AssertCalled(t, "Connect", mock.Anything, mock.Anything, false, mock.Anything)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.NotNil(t, entries[1].CertURI)
+	assert.True(t, sq.InPanic("localhost"))
+}
+
+func TestExecuteServiceQueryReturnsHealthyInstancesAbovePanicThreshold(t *testing.T) {
+	sq := setupPanicThresholdTests(t, 1)
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "localhost:8080", entries[0].Addr)
+	assert.False(t, sq.InPanic("localhost"))
+}
+
+func TestExecuteServiceQueryExcludesMaintenanceFromPanicThreshold(t *testing.T) {
+	sq := setupPanicThresholdTests(t, 1)
+	ses = append(ses, &api.ServiceEntry{
+		Service: &api.AgentService{Address: "localhost", Port: 9090},
+		Node:    &api.Node{Datacenter: "dc1"},
+		Checks:  api.HealthChecks{&api.HealthCheck{CheckID: api.ServiceMaintPrefix + "localhost", Status: api.HealthCritical}},
+	}, &api.ServiceEntry{
+		Service: &api.AgentService{Address: "localhost", Port: 9091},
+		Node:    &api.Node{Datacenter: "dc1"},
+		Checks:  api.HealthChecks{&api.HealthCheck{CheckID: api.NodeMaint, Status: api.HealthCritical}},
+	})
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "localhost:8080", entries[0].Addr)
+	assert.False(t, sq.InPanic("localhost"))
+}
+
+func TestExecuteServiceQueryDoesNotReturnMaintenanceInstancesInPanic(t *testing.T) {
+	sq := setupPanicThresholdTests(t, 2)
+	ses = append(ses, &api.ServiceEntry{
+		Service: &api.AgentService{Address: "localhost", Port: 9090},
+		Node:    &api.Node{Datacenter: "dc1"},
+		Checks:  api.HealthChecks{&api.HealthCheck{CheckID: api.ServiceMaintPrefix + "localhost", Status: api.HealthCritical}},
+	})
+	logger := NewMockLogger()
+	sq.Logger = logger
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	for _, e := range entries {
+		assert.NotEqual(t, "localhost:9090", e.Addr)
+	}
+	assert.True(t, sq.InPanic("localhost"))
+	logger.AssertCalled(t, "Warn", "panic threshold reached, returning all instances", []interface{}{"service", "localhost", "healthy", 1, "total", 3})
+}
+
+func TestExecuteServiceQueryLogsPanicRecovery(t *testing.T) {
+	sq := setupPanicThresholdTests(t, 2)
+	logger := NewMockLogger()
+	sq.Logger = logger
+	sq.Execute("localhost", nil)
+	ses = ses[:1]
+
+	entries, err := sq.Execute("localhost", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.False(t, sq.InPanic("localhost"))
+	logger.AssertCalled(t, "Info", "panic threshold recovered", []interface{}{"service", "localhost", "healthy", 1, "total", 1})
+}
@@ -0,0 +1,94 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// defaultBindAddress is the address the sidecar listens on for an upstream
+// when the registration does not set LocalBindAddress
+const defaultBindAddress = "127.0.0.1"
+
+// ConsulAgentServices defines an interface which adheres to the required
+// functions from the github.com/hashicorp/consul/api Agent struct to list the
+// services registered with the local agent
+type ConsulAgentServices interface {
+	Services() (map[string]*api.AgentService, error)
+}
+
+// SidecarQuery resolves a target to the local listener of the Connect
+// sidecar proxy for the upstream with the name of the target. The sidecar is
+// found from the proxy registration for the local service on the agent,
+// connections are made in plain text to the sidecar which secures them with
+// Connect mTLS.
+type SidecarQuery struct {
+	agent ConsulAgentServices
+	// service is the name or ID of the local service whose sidecar is used
+	service string
+}
+
+// NewSidecarQuery creates a new SidecarQuery which uses the sidecar proxy of
+// the local service, service is the name or the ID of the local service
+func NewSidecarQuery(client *api.Client, service string) *SidecarQuery {
+	return &SidecarQuery{agent: client.Agent(), service: service}
+}
+
+// Execute returns the local address of the sidecar upstream for the target,
+// an error is returned when the sidecar is not registered or has no upstream
+// for the target. The options are ignored as the agent API is used.
+func (s *SidecarQuery) Execute(name string, options *api.QueryOptions) (ses []ServiceEntry, err error) {
+	_, span := startSpan(context.Background(), "catalog.SidecarQuery.Execute", name)
+	defer func() { endSpan(span, ses, err) }()
+
+	services, err := s.agent.Services()
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := s.sidecar(services)
+	if proxy == nil {
+		return nil, fmt.Errorf("No sidecar proxy registered for service %s", s.service)
+	}
+
+	for _, u := range proxy.Proxy.Upstreams {
+		if u.DestinationName != name {
+			continue
+		}
+
+		addr := u.LocalBindAddress
+		if addr == "" {
+			addr = defaultBindAddress
+		}
+
+		setDatacenter(span, u.Datacenter)
+
+		return []ServiceEntry{
+			ServiceEntry{
+				Addr:   net.JoinHostPort(addr, strconv.Itoa(u.LocalBindPort)),
+				Weight: 1,
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Sidecar proxy %s has no upstream for %s", proxy.ID, name)
+}
+
+// sidecar returns the proxy registration for the local service, nil is
+// returned when there is no proxy registered
+func (s *SidecarQuery) sidecar(services map[string]*api.AgentService) *api.AgentService {
+	for _, svc := range services {
+		if svc.Kind != api.ServiceKindConnectProxy || svc.Proxy == nil {
+			continue
+		}
+
+		if svc.Proxy.DestinationServiceName == s.service || svc.Proxy.DestinationServiceID == s.service {
+			return svc
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var sidecarMock *MockConsulAgent
+
+func setupSidecarQueryTests(t *testing.T) *SidecarQuery {
+	sidecarMock = &MockConsulAgent{}
+	sidecarMock.On("Services").Return(map[string]*api.AgentService{
+		"web": &api.AgentService{ID: "web", Service: "web", Port: 8080},
+		"web-sidecar-proxy": &api.AgentService{
+			Kind:    api.ServiceKindConnectProxy,
+			ID:      "web-sidecar-proxy",
+			Service: "web-sidecar-proxy",
+			Port:    21000,
+			Proxy: &api.AgentServiceConnectProxyConfig{
+				DestinationServiceName: "web",
+				DestinationServiceID:   "web-1",
+				Upstreams: []api.Upstream{
+					api.Upstream{DestinationName: "api", LocalBindPort: 9090},
+					api.Upstream{DestinationName: "db", LocalBindAddress: "::1", LocalBindPort: 5432, Datacenter: "dc2"},
+				},
+			},
+		},
+	}, nil)
+
+	return &SidecarQuery{agent: sidecarMock, service: "web"}
+}
+
+func TestExecuteSidecarQueryReturnsUpstreamListener(t *testing.T) {
+	sq := setupSidecarQueryTests(t)
+
+	ses, err := sq.Execute("api", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, ses, 1)
+	assert.Equal(t, "127.0.0.1:9090", ses[0].Addr)
+	assert.Equal(t, 1, ses[0].Weight)
+	assert.Nil(t, ses[0].CertURI)
+}
+
+func TestExecuteSidecarQueryReturnsUpstreamBindAddress(t *testing.T) {
+	sq := setupSidecarQueryTests(t)
+
+	ses, err := sq.Execute("db", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "[::1]:5432", ses[0].Addr)
+}
+
+func TestExecuteSidecarQueryFindsSidecarByServiceID(t *testing.T) {
+	sq := setupSidecarQueryTests(t)
+	sq.service = "web-1"
+
+	_, err := sq.Execute("api", nil)
+
+	assert.NoError(t, err)
+}
+
+func TestExecuteSidecarQueryReturnsErrorWhenNoUpstream(t *testing.T) {
+	sq := setupSidecarQueryTests(t)
+
+	_, err := sq.Execute("cache", nil)
+
+	assert.EqualError(t, err, "Sidecar proxy web-sidecar-proxy has no upstream for cache")
+}
+
+func TestExecuteSidecarQueryReturnsErrorWhenNoSidecar(t *testing.T) {
+	sq := setupSidecarQueryTests(t)
+	sq.service = "payments"
+
+	_, err := sq.Execute("api", nil)
+
+	assert.EqualError(t, err, "No sidecar proxy registered for service payments")
+}
+
+func TestExecuteSidecarQueryReturnsErrorWhenAgentError(t *testing.T) {
+	sq := setupSidecarQueryTests(t)
+	sidecarMock.ExpectedCalls = make([]*mock.Call, 0)
+	sidecarMock.On("Services").Return(nil, fmt.Errorf("boom"))
+
+	_, err := sq.Execute("api", nil)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,23 @@
+package catalog
+
+import "github.com/hashicorp/consul/api"
+
+// StaticQuery implements a query which always returns the same list of
+// endpoints regardless of the name, this can be combined with other queries
+// using a CompositeQuery to add endpoints which are not registered in Consul
+type StaticQuery struct {
+	entries []ServiceEntry
+}
+
+// NewStaticQuery creates a new StaticQuery which returns the given endpoints
+func NewStaticQuery(entries ...ServiceEntry) *StaticQuery {
+	return &StaticQuery{entries}
+}
+
+// Execute returns the static endpoints, the name and options are ignored
+func (s *StaticQuery) Execute(name string, options *api.QueryOptions) ([]ServiceEntry, error) {
+	ses := make([]ServiceEntry, len(s.entries))
+	copy(ses, s.entries)
+
+	return ses, nil
+}
@@ -0,0 +1,16 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteStaticQueryReturnsEntries(t *testing.T) {
+	sq := NewStaticQuery(ServiceEntry{Addr: "localhost:8080", Weight: 1})
+
+	entries, err := sq.Execute("anything", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ServiceEntry{ServiceEntry{Addr: "localhost:8080", Weight: 1}}, entries)
+}
@@ -0,0 +1,39 @@
+package catalog
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer for the query spans, it is named after
+// the catalog package so query spans can be told apart from dial spans
+const tracerName = "github.com/nicholasjackson/grpc-consul-resolver/catalog"
+
+// startSpan starts a span for a query against the target
+func startSpan(ctx context.Context, name, target string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attribute.String("target", target)))
+}
+
+// setDatacenter adds the datacenter which was queried to the span
+func setDatacenter(span trace.Span, datacenter string) {
+	if datacenter != "" {
+		span.SetAttributes(attribute.String("datacenter", datacenter))
+	}
+}
+
+// endSpan records the number of endpoints returned or the error and ends the
+// span
+func endSpan(span trace.Span, ses []ServiceEntry, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("endpoints", len(ses)))
+	}
+
+	span.End()
+}
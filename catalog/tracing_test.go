@@ -0,0 +1,83 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func setupTracing(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	return sr
+}
+
+func spanAttributes(s sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range s.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+
+	return attrs
+}
+
+func TestExecuteServiceQueryCreatesSpans(t *testing.T) {
+	sr := setupTracing(t)
+	sq := setupServiceQueryTests(t, true)
+	sq.Datacenter = "dc2"
+
+	sq.Execute("web", nil)
+
+	spans := sr.Ended()
+	assert.Len(t, spans, 2)
+
+	roots := spans[0]
+	assert.Equal(t, "catalog.ConnectCARoots", roots.Name())
+	assert.Equal(t, "abc.com", spanAttributes(roots)["trust_domain"].AsString())
+
+	execute := spans[1]
+	assert.Equal(t, "catalog.ServiceQuery.Execute", execute.Name())
+	assert.Equal(t, execute.SpanContext().SpanID(), roots.Parent().SpanID())
+
+	attrs := spanAttributes(execute)
+	assert.Equal(t, "web", attrs["target"].AsString())
+	assert.Equal(t, "dc2", attrs["datacenter"].AsString())
+	assert.Equal(t, int64(1), attrs["endpoints"].AsInt64())
+}
+
+func TestExecuteServiceQueryRecordsErrorOnSpan(t *testing.T) {
+	sr := setupTracing(t)
+	sq := setupServiceQueryTests(t, false)
+	healthMock.ExpectedCalls = make([]*mock.Call, 0)
+	healthMock.On("Service", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil, fmt.Errorf("boom"))
+
+	sq.Execute("web", nil)
+
+	spans := sr.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+}
+
+func TestExecutePreparedQueryCreatesSpanWithDatacenter(t *testing.T) {
+	sr := setupTracing(t)
+	pqMock := &MockConsulPreparedQuery{}
+	pqMock.On("Execute", mock.Anything, mock.Anything).Return(func() *api.PreparedQueryExecuteResponse {
+		return &api.PreparedQueryExecuteResponse{Datacenter: "dc1"}
+	}, nil, nil)
+
+	NewPreparedQuery(pqMock).Execute("web-query", nil)
+
+	spans := sr.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "catalog.PreparedQuery.Execute", spans[0].Name())
+	assert.Equal(t, "dc1", spanAttributes(spans[0])["datacenter"].AsString())
+}
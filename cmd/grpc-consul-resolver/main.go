@@ -0,0 +1,58 @@
+// Command grpc-consul-resolver resolves a target using the same queries as
+// the gRPC resolver, it can be used to verify the endpoints gRPC clients will
+// see without writing any code.
+// example usage:
+// grpc-consul-resolver resolve -addr http://localhost:8500 -type connect -json web
+// grpc-consul-resolver watch -type prepared web-query
+// grpc-consul-resolver probe -type connect -reflection web
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `Usage: grpc-consul-resolver <command> [options] <target>
+
+Commands:
+  resolve  Resolve the target and print the endpoints
+  watch    Watch the target and print the endpoint updates
+  probe    Resolve the target and health check every endpoint
+`
+
+func main() {
+	stop := make(chan struct{})
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		close(stop)
+	}()
+
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, stop))
+}
+
+// run executes the command in args and returns the exit code, stop is closed
+// to end long running commands
+func run(args []string, stdout, stderr io.Writer, stop <-chan struct{}) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return 1
+	}
+
+	switch args[0] {
+	case "resolve":
+		return resolveCommand(args[1:], stdout, stderr)
+	case "watch":
+		return watchCommand(args[1:], stdout, stderr, stop)
+	case "probe":
+		return probeCommand(args[1:], stdout, stderr)
+	}
+
+	fmt.Fprintf(stderr, "Unknown command %s\n\n%s", args[0], usage)
+	return 1
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/nicholasjackson/grpc-consul-resolver/consultest"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupServer(t *testing.T) *consultest.Server {
+	s := consultest.NewServer()
+	s.RegisterService(&api.AgentService{ID: "web-1", Service: "web", Port: 8080, Tags: []string{"v1"}})
+
+	return s
+}
+
+func TestRunReturnsErrorForUnknownCommand(t *testing.T) {
+	stderr := bytes.NewBuffer(nil)
+
+	code := run([]string{"unknown"}, io.Discard, stderr, nil)
+
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "Unknown command unknown")
+}
+
+func TestResolveReturnsErrorWithoutTarget(t *testing.T) {
+	stderr := bytes.NewBuffer(nil)
+
+	code := run([]string{"resolve"}, io.Discard, stderr, nil)
+
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "A single target must be specified")
+}
+
+func TestResolveReturnsErrorForUnknownQueryType(t *testing.T) {
+	stderr := bytes.NewBuffer(nil)
+
+	code := run([]string{"resolve", "-type", "dns", "web"}, io.Discard, stderr, nil)
+
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "Unknown query type dns")
+}
+
+func TestResolvePrintsEndpoints(t *testing.T) {
+	s := setupServer(t)
+	defer s.Close()
+	stdout := bytes.NewBuffer(nil)
+
+	code := run([]string{"resolve", "-addr", s.Addr(), "web"}, stdout, io.Discard, nil)
+
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "127.0.0.1:8080 weight=1 tags=v1\n", stdout.String())
+}
+
+func TestResolvePrintsJSON(t *testing.T) {
+	s := setupServer(t)
+	defer s.Close()
+	stdout := bytes.NewBuffer(nil)
+
+	code := run([]string{"resolve", "-addr", s.Addr(), "-json", "web"}, stdout, io.Discard, nil)
+
+	endpoints := []endpoint{}
+	json.Unmarshal(stdout.Bytes(), &endpoints)
+
+	assert.Equal(t, 0, code)
+	assert.Len(t, endpoints, 1)
+	assert.Equal(t, "127.0.0.1:8080", endpoints[0].Address)
+	assert.Equal(t, []string{"v1"}, endpoints[0].Tags)
+}
+
+func TestWatchStreamsUpdates(t *testing.T) {
+	s := setupServer(t)
+	defer s.Close()
+
+	pr, pw := io.Pipe()
+	stop := make(chan struct{})
+	done := make(chan int)
+
+	go func() {
+		done <- run([]string{"watch", "-addr", s.Addr(), "-interval", "10ms", "-json", "web"}, pw, io.Discard, stop)
+		pw.Close()
+	}()
+
+	lines := bufio.NewScanner(pr)
+
+	u := update{}
+	assert.True(t, lines.Scan())
+	json.Unmarshal(lines.Bytes(), &u)
+	assert.Equal(t, update{Op: "add", Address: "127.0.0.1:8080", Weight: 1}, u)
+
+	s.DeregisterService("web-1")
+
+	u = update{}
+	assert.True(t, lines.Scan())
+	json.Unmarshal(lines.Bytes(), &u)
+	assert.Equal(t, update{Op: "delete", Address: "127.0.0.1:8080", Weight: 1}, u)
+
+	close(stop)
+	go io.Copy(io.Discard, pr)
+
+	select {
+	case code := <-done:
+		assert.Equal(t, 0, code)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for watch to stop")
+	}
+}
+
+func TestQuerySetsDatacenterOnServiceQuery(t *testing.T) {
+	qf := &queryFlags{queryType: "connect", datacenter: "dc2"}
+	client, _ := qf.client()
+
+	q, err := qf.query(client)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "dc2", q.(*catalog.ServiceQuery).Datacenter)
+}
+
+func TestQuerySetsDatacenterOnPreparedQuery(t *testing.T) {
+	qf := &queryFlags{queryType: "prepared", datacenter: "dc2"}
+	client, _ := qf.client()
+
+	q, err := qf.query(client)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "dc2", q.(*catalog.PreparedQuery).Datacenter)
+}
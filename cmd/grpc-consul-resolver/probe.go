@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/consul/connect"
+	resolver "github.com/nicholasjackson/grpc-consul-resolver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/naming"
+	"google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+)
+
+// probeResult is the result of probing a single endpoint
+type probeResult struct {
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	// LatencyMs is the time taken to dial the endpoint and complete the
+	// health check
+	LatencyMs float64 `json:"latency_ms"`
+	// Identity is the SPIFFE ID presented by the endpoint, only set for
+	// Connect services
+	Identity string   `json:"identity,omitempty"`
+	Services []string `json:"services,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// prober dials and health checks the endpoints resolved by a ConsulResolver
+type prober struct {
+	r              *resolver.ConsulResolver
+	target         string
+	connectService *connect.Service
+	healthService  string
+	reflection     bool
+	timeout        time.Duration
+}
+
+// probeCommand resolves the target and health checks every endpoint, the
+// exit code is 2 when any endpoint is not serving
+func probeCommand(args []string, stdout, stderr io.Writer) int {
+	qf := &queryFlags{}
+	fs := newFlagSet("probe", qf, stderr)
+	serviceName := fs.String("service", "grpc-consul-resolver", "Name of the local service used to request the Connect client certificate")
+	healthService := fs.String("health-service", "", "Name of the service to check with the gRPC health protocol, defaults to the server health")
+	reflection := fs.Bool("reflection", false, "List the services of each endpoint using gRPC reflection")
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout for resolving the target and for each endpoint")
+
+	target, err := parse(fs, args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	client, err := qf.client()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	q, err := qf.query(client)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	p := &prober{
+		r:             resolver.NewResolver(q),
+		target:        target,
+		healthService: *healthService,
+		reflection:    *reflection,
+		timeout:       *timeout,
+	}
+
+	if qf.queryType == "connect" {
+		p.connectService, err = connect.NewService(*serviceName, client)
+		if err != nil {
+			fmt.Fprintf(stderr, "Unable to create connect service %s\n", err)
+			return 1
+		}
+		defer p.connectService.Close()
+	}
+
+	addrs, err := p.resolve()
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to resolve %s: %s\n", target, err)
+		return 1
+	}
+
+	results := p.probeAll(addrs)
+
+	if qf.json {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		writeTable(stdout, results)
+	}
+
+	for _, res := range results {
+		if res.Status != grpc_health_v1.HealthCheckResponse_SERVING.String() {
+			return 2
+		}
+	}
+
+	return 0
+}
+
+// resolve returns the addresses for the target from the resolver, the
+// watcher blocks until the target has endpoints so the first update is
+// bounded by the timeout
+func (p *prober) resolve() ([]string, error) {
+	nw, err := p.r.Resolve(p.target)
+	if err != nil {
+		return nil, err
+	}
+	defer nw.Close()
+
+	type next struct {
+		updates []*naming.Update
+		err     error
+	}
+
+	done := make(chan next, 1)
+	go func() {
+		nu, err := nw.Next()
+		done <- next{nu, err}
+	}()
+
+	select {
+	case n := <-done:
+		if n.err != nil {
+			return nil, n.err
+		}
+
+		addrs := make([]string, 0, len(n.updates))
+		for _, u := range n.updates {
+			addrs = append(addrs, u.Addr)
+		}
+
+		sort.Strings(addrs)
+		return addrs, nil
+	case <-time.After(p.timeout):
+		return nil, fmt.Errorf("No endpoints found")
+	}
+}
+
+// probeAll probes the endpoints concurrently, results are returned in the
+// order of the addresses
+func (p *prober) probeAll(addrs []string) []probeResult {
+	results := make([]probeResult, len(addrs))
+
+	wg := sync.WaitGroup{}
+	for i, addr := range addrs {
+		wg.Add(1)
+
+		go func(i int, addr string) {
+			defer wg.Done()
+			results[i] = p.probe(addr)
+		}(i, addr)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// probe dials the endpoint and runs the gRPC health check
+func (p *prober) probe(addr string) (res probeResult) {
+	res.Address = addr
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	defer func() {
+		res.LatencyMs = float64(time.Since(start)) / float64(time.Millisecond)
+	}()
+
+	// the dialer is called from a gRPC goroutine
+	var identity string
+	var identityMutex sync.Mutex
+
+	opts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithBlock()}
+	if p.connectService != nil {
+		opts = append(opts, grpc.WithDialer(func(addr string, t time.Duration) (net.Conn, error) {
+			conn, err := p.r.DialConnectTarget(ctx, p.target, addr, p.connectService)
+			if tc, ok := conn.(*tls.Conn); ok {
+				identityMutex.Lock()
+				identity = peerIdentity(tc)
+				identityMutex.Unlock()
+			}
+
+			return conn, err
+		}))
+	}
+
+	conn, err := grpc.DialContext(ctx, addr, opts...)
+	if err != nil {
+		return p.failed(res, err)
+	}
+	defer conn.Close()
+
+	identityMutex.Lock()
+	res.Identity = identity
+	identityMutex.Unlock()
+
+	hr, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: p.healthService})
+	if err != nil {
+		return p.failed(res, err)
+	}
+
+	res.Status = hr.Status.String()
+
+	if p.reflection {
+		res.Services, err = listServices(ctx, conn)
+		if err != nil {
+			res.Error = err.Error()
+		}
+	}
+
+	return res
+}
+
+func (p *prober) failed(res probeResult, err error) probeResult {
+	res.Status = "ERROR"
+	res.Error = err.Error()
+
+	return res
+}
+
+// peerIdentity returns the SPIFFE ID from the certificate of the peer
+func peerIdentity(tc *tls.Conn) string {
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 || len(certs[0].URIs) == 0 {
+		return ""
+	}
+
+	return certs[0].URIs[0].String()
+}
+
+// listServices returns the services registered with the server using gRPC
+// reflection
+func listServices(ctx context.Context, conn *grpc.ClientConn) ([]string, error) {
+	stream, err := grpc_reflection_v1alpha.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	err = stream.Send(&grpc_reflection_v1alpha.ServerReflectionRequest{
+		MessageRequest: &grpc_reflection_v1alpha.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]string, 0)
+	for _, s := range resp.GetListServicesResponse().GetService() {
+		services = append(services, s.Name)
+	}
+
+	sort.Strings(services)
+	return services, nil
+}
+
+func writeTable(out io.Writer, results []probeResult) {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tSTATUS\tLATENCY\tIDENTITY\tSERVICES\tERROR")
+
+	for _, r := range results {
+		fmt.Fprintf(
+			tw, "%s\t%s\t%.1fms\t%s\t%s\t%s\n",
+			r.Address, r.Status, r.LatencyMs, orDash(r.Identity), orDash(strings.Join(r.Services, ",")), orDash(r.Error),
+		)
+	}
+
+	tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/nicholasjackson/grpc-consul-resolver/consultest"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+func setupProbe(t *testing.T) (*consultest.Server, *health.Server, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	hs := health.NewServer()
+	gs := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(gs, hs)
+	reflection.Register(gs)
+	go gs.Serve(l)
+
+	s := consultest.NewServer()
+	s.RegisterService(&api.AgentService{ID: "web-1", Service: "web", Port: l.Addr().(*net.TCPAddr).Port})
+
+	return s, hs, func() {
+		gs.Stop()
+		s.Close()
+	}
+}
+
+func runProbe(t *testing.T, args ...string) (int, []probeResult) {
+	stdout := bytes.NewBuffer(nil)
+
+	code := run(append([]string{"probe", "-json"}, args...), stdout, io.Discard, nil)
+
+	results := []probeResult{}
+	json.Unmarshal(stdout.Bytes(), &results)
+
+	return code, results
+}
+
+func TestProbeReturnsServingEndpoints(t *testing.T) {
+	s, _, cleanup := setupProbe(t)
+	defer cleanup()
+
+	code, results := runProbe(t, "-addr", s.Addr(), "-reflection", "web")
+
+	assert.Equal(t, 0, code)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "SERVING", results[0].Status)
+	assert.Empty(t, results[0].Error)
+	assert.True(t, results[0].LatencyMs > 0)
+	assert.Equal(t, []string{"grpc.health.v1.Health", "grpc.reflection.v1alpha.ServerReflection"}, results[0].Services)
+}
+
+func TestProbeReturnsNotServingEndpoints(t *testing.T) {
+	s, hs, cleanup := setupProbe(t)
+	defer cleanup()
+	hs.SetServingStatus("web", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+	code, results := runProbe(t, "-addr", s.Addr(), "-health-service", "web", "web")
+
+	assert.Equal(t, 2, code)
+	assert.Equal(t, "NOT_SERVING", results[0].Status)
+	assert.Nil(t, results[0].Services)
+}
+
+func TestProbeReturnsErrorForUnreachableEndpoints(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+	s.RegisterService(&api.AgentService{ID: "web-1", Service: "web", Address: "127.0.0.1", Port: 1})
+
+	code, results := runProbe(t, "-addr", s.Addr(), "-timeout", "100ms", "web")
+
+	assert.Equal(t, 2, code)
+	assert.Equal(t, "ERROR", results[0].Status)
+	assert.NotEmpty(t, results[0].Error)
+}
+
+func TestProbeReturnsErrorWhenNoEndpoints(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+	stderr := bytes.NewBuffer(nil)
+
+	code := run([]string{"probe", "-addr", s.Addr(), "-timeout", "100ms", "web"}, io.Discard, stderr, nil)
+
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "No endpoints found")
+}
+
+func TestWriteTableFormatsResults(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+
+	writeTable(out, []probeResult{
+		probeResult{Address: "127.0.0.1:8080", Status: "SERVING", LatencyMs: 1.25, Identity: "spiffe://abc.com/ns/default/dc/dc1/svc/web"},
+	})
+
+	assert.Equal(t, "ADDRESS         STATUS   LATENCY  IDENTITY                                    SERVICES  ERROR\n"+
+		"127.0.0.1:8080  SERVING  1.2ms    spiffe://abc.com/ns/default/dc/dc1/svc/web  -         -\n", out.String())
+}
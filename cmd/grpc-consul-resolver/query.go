@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+)
+
+// queryFlags are the flags shared by the commands which build a catalog.Query
+type queryFlags struct {
+	addr       string
+	queryType  string
+	datacenter string
+	json       bool
+}
+
+// newFlagSet returns a FlagSet containing the query flags
+func newFlagSet(name string, qf *queryFlags, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&qf.addr, "addr", "", "Address of the Consul HTTP API, defaults to CONSUL_HTTP_ADDR or http://127.0.0.1:8500")
+	fs.StringVar(&qf.queryType, "type", "service", "Type of query, one of service, connect or prepared")
+	fs.StringVar(&qf.datacenter, "datacenter", "", "Datacenter to query, defaults to the datacenter of the agent")
+	fs.BoolVar(&qf.json, "json", false, "Output JSON")
+
+	return fs
+}
+
+// parse parses the flags and returns the target
+func parse(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("A single target must be specified")
+	}
+
+	return fs.Arg(0), nil
+}
+
+// client returns a Consul API client for the address
+func (qf *queryFlags) client() (*api.Client, error) {
+	conf := api.DefaultConfig()
+	if qf.addr != "" {
+		conf.Address = qf.addr
+	}
+
+	client, err := api.NewClient(conf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create Consul client: %s", err)
+	}
+
+	return client, nil
+}
+
+// query returns the catalog.Query for the query type
+func (qf *queryFlags) query(client *api.Client) (catalog.Query, error) {
+	switch qf.queryType {
+	case "service", "connect":
+		sq := catalog.NewServiceQuery(client, qf.queryType == "connect")
+		sq.Datacenter = qf.datacenter
+		return sq, nil
+	case "prepared":
+		pq := catalog.NewPreparedQuery(client.PreparedQuery())
+		pq.Datacenter = qf.datacenter
+		return pq, nil
+	}
+
+	return nil, fmt.Errorf("Unknown query type %s", qf.queryType)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+)
+
+// endpoint is the output format of a catalog.ServiceEntry
+type endpoint struct {
+	Address string   `json:"address"`
+	CertURI string   `json:"cert_uri,omitempty"`
+	Weight  int      `json:"weight"`
+	SNI     string   `json:"sni,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+func newEndpoint(se catalog.ServiceEntry) endpoint {
+	e := endpoint{Address: se.Addr, Weight: se.Weight, SNI: se.SNI, Tags: se.Tags}
+	if se.CertURI != nil {
+		e.CertURI = se.CertURI.URI().String()
+	}
+
+	return e
+}
+
+func (e endpoint) String() string {
+	s := fmt.Sprintf("%s weight=%d", e.Address, e.Weight)
+
+	if e.CertURI != "" {
+		s += " cert_uri=" + e.CertURI
+	}
+
+	if e.SNI != "" {
+		s += " sni=" + e.SNI
+	}
+
+	if len(e.Tags) > 0 {
+		s += " tags=" + strings.Join(e.Tags, ",")
+	}
+
+	return s
+}
+
+// resolveCommand resolves the target once and prints the endpoints
+func resolveCommand(args []string, stdout, stderr io.Writer) int {
+	qf := &queryFlags{}
+	fs := newFlagSet("resolve", qf, stderr)
+
+	target, err := parse(fs, args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	client, err := qf.client()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	q, err := qf.query(client)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	ses, err := q.Execute(target, nil)
+	if err != nil {
+		fmt.Fprintf(stderr, "Unable to resolve %s: %s\n", target, err)
+		return 1
+	}
+
+	endpoints := make([]endpoint, 0, len(ses))
+	for _, se := range ses {
+		endpoints = append(endpoints, newEndpoint(se))
+	}
+
+	if qf.json {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(endpoints)
+
+		return 0
+	}
+
+	for _, e := range endpoints {
+		fmt.Fprintln(stdout, e)
+	}
+
+	return 0
+}
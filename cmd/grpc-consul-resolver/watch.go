@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	resolver "github.com/nicholasjackson/grpc-consul-resolver"
+	"google.golang.org/grpc/naming"
+)
+
+// update is the output format of a naming.Update
+type update struct {
+	Op      string `json:"op"`
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+// watchCommand watches the target and prints the updates until stop is closed
+func watchCommand(args []string, stdout, stderr io.Writer, stop <-chan struct{}) int {
+	qf := &queryFlags{}
+	fs := newFlagSet("watch", qf, stderr)
+	interval := fs.Duration("interval", 10*time.Second, "Interval between queries")
+
+	target, err := parse(fs, args)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	client, err := qf.client()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	q, err := qf.query(client)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	w := resolver.NewConsulWatcher(target, q, *interval)
+	w.Weighted = true
+
+	go func() {
+		<-stop
+		w.Close()
+	}()
+
+	enc := json.NewEncoder(stdout)
+	for {
+		nu, err := w.Next()
+		if err != nil {
+			// keep watching so that the target is reported when it recovers
+			fmt.Fprintf(stderr, "Unable to resolve %s: %s\n", target, err)
+
+			select {
+			case <-stop:
+				return 0
+			case <-time.After(*interval):
+			}
+
+			continue
+		}
+
+		// Next returns no updates once the watcher has been closed
+		if nu == nil {
+			return 0
+		}
+
+		for _, u := range nu {
+			out := newUpdate(u)
+
+			if qf.json {
+				enc.Encode(out)
+				continue
+			}
+
+			fmt.Fprintf(stdout, "%s %s weight=%d\n", out.Op, out.Address, out.Weight)
+		}
+	}
+}
+
+func newUpdate(u *naming.Update) update {
+	out := update{Op: "add", Address: u.Addr}
+	if u.Op == naming.Delete {
+		out.Op = "delete"
+	}
+
+	if md, ok := u.Metadata.(resolver.Metadata); ok {
+		out.Weight = md.Weight
+	}
+
+	return out
+}
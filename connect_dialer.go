@@ -0,0 +1,90 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+)
+
+// dialMeshGateway creates a Connect mTLS connection to the destination service
+// through a mesh gateway. The connect package does not allow the SNI to be set
+// which the gateway requires to route the connection, instead the TLS config
+// is built from the cached leaf certificate and CA roots of the local Connect
+// service which are passed as base.
+func dialMeshGateway(ctx context.Context, base *tls.Config, se catalog.ServiceEntry) (net.Conn, error) {
+	cfg := base.Clone()
+	cfg.ServerName = se.SNI
+	// Connect certificates identify the service with a SPIFFE URI rather
+	// than a DNS name, the certificate is verified after the handshake
+	cfg.InsecureSkipVerify = true
+	// remove the server side hooks, the connection is made as a client
+	cfg.VerifyPeerCertificate = nil
+	cfg.GetConfigForClient = nil
+
+	pool := cfg.RootCAs
+	if pool == nil {
+		return nil, fmt.Errorf("Connect CA roots have not been loaded")
+	}
+
+	var dialer net.Dialer
+	tcpConn, err := dialer.DialContext(ctx, "tcp", se.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(tcpConn, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		tlsConn.SetDeadline(deadline)
+	}
+
+	err = tlsConn.Handshake()
+	if err == nil {
+		err = verifyServerCert(tlsConn.ConnectionState().PeerCertificates, pool, se)
+	}
+
+	if err != nil {
+		tlsConn.Close()
+		return nil, err
+	}
+
+	// the deadline only applies to the handshake
+	tlsConn.SetDeadline(time.Time{})
+
+	return tlsConn, nil
+}
+
+// verifyServerCert checks the certificate presented by the destination is
+// signed by the Connect CA and contains the expected CertURI
+func verifyServerCert(certs []*x509.Certificate, roots *x509.CertPool, se catalog.ServiceEntry) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("Peer did not present a certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return err
+	}
+
+	expected := se.CertURI.URI().String()
+	for _, uri := range certs[0].URIs {
+		if uri.String() == expected {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Peer certificate does not match %s", expected)
+}
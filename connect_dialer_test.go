@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/agent/connect"
+	consulconnect "github.com/hashicorp/consul/connect"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupMeshGateway starts a TLS server presenting a certificate for the web
+// service, the SNI sent by the client is written to the returned channel. The
+// returned Connect service has a client certificate signed by the same CA.
+func setupMeshGateway(t *testing.T) (*consulconnect.Service, net.Listener, chan string) {
+	ca := connect.TestCA(t, nil)
+	serverCert, serverKey := connect.TestLeaf(t, "web", ca)
+	clientCert, clientKey := connect.TestLeaf(t, "client", ca)
+
+	cert, err := tls.X509KeyPair([]byte(serverCert), []byte(serverKey))
+	assert.NoError(t, err)
+
+	sni := make(chan string, 1)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni <- h.ServerName
+			return nil, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	client, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+	assert.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM([]byte(ca.RootCert))
+
+	svc, err := consulconnect.NewDevServiceWithTLSConfig("client", nil, &tls.Config{
+		Certificates: []tls.Certificate{client},
+		RootCAs:      roots,
+	})
+	assert.NoError(t, err)
+
+	return svc, l, sni
+}
+
+// gatewayEntry returns the endpoint for the service routed through the mesh
+// gateway listening on addr
+func gatewayEntry(addr, service string) catalog.ServiceEntry {
+	return catalog.ServiceEntry{
+		Addr: addr,
+		SNI:  service + ".default.dc1.internal." + connect.TestClusterID + ".consul",
+		CertURI: &connect.SpiffeIDService{
+			Host:       connect.TestClusterID + ".consul",
+			Namespace:  "default",
+			Datacenter: "dc1",
+			Service:    service,
+		},
+	}
+}
+
+func TestDialMeshGatewaySetsSNIAndVerifiesCertURI(t *testing.T) {
+	svc, l, sni := setupMeshGateway(t)
+	defer l.Close()
+
+	conn, err := dialMeshGateway(context.Background(), svc.ServerTLSConfig(), gatewayEntry(l.Addr().String(), "web"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "web.default.dc1.internal."+connect.TestClusterID+".consul", <-sni)
+	conn.Close()
+}
+
+func TestDialMeshGatewayReturnsErrorWhenCertURIDoesNotMatch(t *testing.T) {
+	svc, l, _ := setupMeshGateway(t)
+	defer l.Close()
+
+	_, err := dialMeshGateway(context.Background(), svc.ServerTLSConfig(), gatewayEntry(l.Addr().String(), "api"))
+
+	assert.Error(t, err)
+}
+
+// setupGatewayTargets returns a resolver with the web and api targets both
+// routed through the mesh gateway at addr
+func setupGatewayTargets(addr string) *ConsulResolver {
+	r := NewResolver(nil)
+
+	for _, target := range []string{"web", "api"} {
+		w := r.newWatcher(target)
+		w.addressCache[addr] = gatewayEntry(addr, target)
+		r.watchers[target] = w
+	}
+
+	return r
+}
+
+func TestConnectTargetDialerUsesEndpointOfTarget(t *testing.T) {
+	svc, l, sni := setupMeshGateway(t)
+	defer l.Close()
+	r := setupGatewayTargets(l.Addr().String())
+
+	conn, err := r.dialer(svc, r.targetLookup("web"))(l.Addr().String(), time.Second)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "web.default.dc1.internal."+connect.TestClusterID+".consul", <-sni)
+	conn.Close()
+}
+
+func TestDialConnectTargetUsesEndpointOfTarget(t *testing.T) {
+	svc, l, sni := setupMeshGateway(t)
+	defer l.Close()
+	r := setupGatewayTargets(l.Addr().String())
+
+	conn, err := r.DialConnectTarget(context.Background(), "web", l.Addr().String(), svc)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "web.default.dc1.internal."+connect.TestClusterID+".consul", <-sni)
+	conn.Close()
+}
+
+func TestConnectDialerReturnsErrorWhenGatewayRoutesToMoreThanOneTarget(t *testing.T) {
+	svc, l, _ := setupMeshGateway(t)
+	defer l.Close()
+	r := setupGatewayTargets(l.Addr().String())
+
+	_, err := r.dialer(svc, r.dialServiceEntry)(l.Addr().String(), time.Second)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "use ConnectTargetDialer")
+}
+
+func TestConnectDialerAppliesDialTimeout(t *testing.T) {
+	svc, _, _ := setupMeshGateway(t)
+
+	// a listener which never completes the TLS handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	r := NewResolver(nil)
+	w := r.newWatcher("web")
+	w.addressCache[l.Addr().String()] = gatewayEntry(l.Addr().String(), "web")
+	r.watchers["web"] = w
+
+	start := time.Now()
+	_, err = r.dialer(svc, r.targetLookup("web"))(l.Addr().String(), 50*time.Millisecond)
+
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second, "Dial should return when the timeout expires")
+}
@@ -0,0 +1,182 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+)
+
+// ConsulTLSConfig configures TLS for the connection to the Consul HTTP API.
+// When ReloadInterval is set the files are checked for changes and rotated
+// certificates are used for new connections without a restart.
+type ConsulTLSConfig struct {
+	// CAFile is the PEM encoded CA used to verify the Consul server
+	// certificate, when empty the system roots are used
+	CAFile string
+	// CertFile and KeyFile are the PEM encoded client certificate and key
+	// presented to Consul when it verifies client certificates
+	CertFile string
+	KeyFile  string
+	// ServerName is used to verify the hostname of the Consul server
+	// certificate, defaults to the host of the address
+	ServerName string
+	// InsecureSkipVerify disables verification of the Consul server
+	// certificate
+	InsecureSkipVerify bool
+	// ReloadInterval is the interval at which the files are checked for
+	// changes, when 0 the files are only read once
+	ReloadInterval time.Duration
+}
+
+// tlsTransport is a http.RoundTripper which creates a new transport when the
+// certificate files change
+type tlsTransport struct {
+	config ConsulTLSConfig
+	logger catalog.Logger
+
+	mutex     sync.Mutex
+	transport *http.Transport
+	modTimes  map[string]time.Time
+	lastCheck time.Time
+	now       func() time.Time
+}
+
+func newTLSTransport(c ConsulTLSConfig, l catalog.Logger) (*tlsTransport, error) {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, fmt.Errorf("CertFile and KeyFile must both be set")
+	}
+
+	if l == nil {
+		l = catalog.NewNopLogger()
+	}
+
+	t := &tlsTransport{config: c, logger: l, now: time.Now}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+
+	t.lastCheck = t.now()
+
+	return t, nil
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *tlsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return t.current().RoundTrip(r)
+}
+
+// current returns the transport, reloading the files when they have changed
+// since they were last read. When the new files are not valid the previous
+// transport continues to be used.
+func (t *tlsTransport) current() *http.Transport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.config.ReloadInterval <= 0 || t.now().Sub(t.lastCheck) < t.config.ReloadInterval {
+		return t.transport
+	}
+
+	t.lastCheck = t.now()
+
+	if !t.changed() {
+		return t.transport
+	}
+
+	old := t.transport
+	if err := t.load(); err != nil {
+		t.logger.Error("unable to reload Consul TLS certificates", "error", err)
+		return t.transport
+	}
+
+	t.logger.Info("reloaded Consul TLS certificates")
+	old.CloseIdleConnections()
+
+	return t.transport
+}
+
+// load reads the files and creates a new transport
+func (t *tlsTransport) load() error {
+	tc := &tls.Config{
+		ServerName:         t.config.ServerName,
+		InsecureSkipVerify: t.config.InsecureSkipVerify,
+	}
+
+	modTimes := make(map[string]time.Time)
+
+	if t.config.CAFile != "" {
+		pem, err := readFile(t.config.CAFile, modTimes)
+		if err != nil {
+			return err
+		}
+
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificates found in %s", t.config.CAFile)
+		}
+	}
+
+	if t.config.CertFile != "" {
+		cert, err := readFile(t.config.CertFile, modTimes)
+		if err != nil {
+			return err
+		}
+
+		key, err := readFile(t.config.KeyFile, modTimes)
+		if err != nil {
+			return err
+		}
+
+		kp, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return fmt.Errorf("Unable to load client certificate %s", err)
+		}
+
+		tc.Certificates = []tls.Certificate{kp}
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tc
+
+	t.transport = transport
+	t.modTimes = modTimes
+
+	return nil
+}
+
+// changed returns true when any of the files have been modified since they
+// were loaded
+func (t *tlsTransport) changed() bool {
+	for f, mt := range t.modTimes {
+		fi, err := os.Stat(f)
+		if err != nil || !fi.ModTime().Equal(mt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readFile reads the file and records its modification time
+func readFile(f string, modTimes map[string]time.Time) ([]byte, error) {
+	fi, err := os.Stat(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s %s", f, err)
+	}
+
+	data, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s %s", f, err)
+	}
+
+	modTimes[f] = fi.ModTime()
+
+	return data, nil
+}
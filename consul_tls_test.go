@@ -0,0 +1,252 @@
+package resolver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate signed by the parent, when parent is nil
+// a self signed CA is created
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"consul.local"},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// setupTLSServer starts a HTTPS server which requires a client certificate
+// signed by clientCA
+func setupTLSServer(t *testing.T, ca, clientCA *testCert) *httptest.Server {
+	server := newTestCert(t, "server", ca)
+	kp, _ := tls.X509KeyPair(server.certPEM, server.keyPEM)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA.cert)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(`"127.0.0.1:8300"`))
+	}))
+	// handshake errors are expected when the client certificate is rejected
+	s.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{kp},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	s.StartTLS()
+
+	return s
+}
+
+// writeTLSFiles writes the CA and client certificate to the directory
+func writeTLSFiles(t *testing.T, dir string, ca, client *testCert) ConsulTLSConfig {
+	c := ConsulTLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+
+	assert.NoError(t, ioutil.WriteFile(c.CAFile, ca.certPEM, 0600))
+	assert.NoError(t, ioutil.WriteFile(c.CertFile, client.certPEM, 0600))
+	assert.NoError(t, ioutil.WriteFile(c.KeyFile, client.keyPEM, 0600))
+
+	return c
+}
+
+func TestTLSTransportConnectsWithClientCertificate(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	s := setupTLSServer(t, ca, ca)
+	defer s.Close()
+
+	tr, err := newTLSTransport(writeTLSFiles(t, t.TempDir(), ca, newTestCert(t, "client", ca)), nil)
+	assert.NoError(t, err)
+
+	resp, err := (&http.Client{Transport: tr}).Get(s.URL)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTLSTransportVerifiesServerName(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	s := setupTLSServer(t, ca, ca)
+	defer s.Close()
+
+	conf := writeTLSFiles(t, t.TempDir(), ca, newTestCert(t, "client", ca))
+	conf.ServerName = "other.local"
+	tr, _ := newTLSTransport(conf, nil)
+
+	_, err := (&http.Client{Transport: tr}).Get(s.URL)
+
+	assert.Error(t, err)
+}
+
+func TestTLSTransportReloadsRotatedCertificates(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	clientCA := newTestCert(t, "client-ca", nil)
+	s := setupTLSServer(t, ca, clientCA)
+	defer s.Close()
+
+	// the client certificate is not signed by the CA trusted by the server
+	dir := t.TempDir()
+	conf := writeTLSFiles(t, dir, ca, newTestCert(t, "client", ca))
+	conf.ReloadInterval = time.Minute
+
+	now := time.Now()
+	tr, err := newTLSTransport(conf, nil)
+	assert.NoError(t, err)
+	tr.now = func() time.Time { return now }
+	tr.lastCheck = now
+
+	c := &http.Client{Transport: tr}
+	_, err = c.Get(s.URL)
+	assert.Error(t, err)
+
+	writeTLSFiles(t, dir, ca, newTestCert(t, "client", clientCA))
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(conf.CertFile, future, future)
+
+	// files are not checked until the reload interval has passed
+	_, err = c.Get(s.URL)
+	assert.Error(t, err)
+
+	now = now.Add(time.Minute)
+	resp, err := c.Get(s.URL)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestTLSTransportKeepsTransportWhenReloadFails(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	s := setupTLSServer(t, ca, ca)
+	defer s.Close()
+
+	conf := writeTLSFiles(t, t.TempDir(), ca, newTestCert(t, "client", ca))
+	conf.ReloadInterval = time.Nanosecond
+	tr, _ := newTLSTransport(conf, nil)
+
+	os.Remove(conf.KeyFile)
+	resp, err := (&http.Client{Transport: tr}).Get(s.URL)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTLSTransportReturnsErrorForInvalidFiles(t *testing.T) {
+	_, err := newTLSTransport(ConsulTLSConfig{CertFile: "client.pem"}, nil)
+	assert.EqualError(t, err, "CertFile and KeyFile must both be set")
+
+	_, err = newTLSTransport(ConsulTLSConfig{CAFile: "/does/not/exist"}, nil)
+	assert.Error(t, err)
+}
+
+func TestNewConnectsToConsulWithTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	s := setupTLSServer(t, ca, ca)
+	defer s.Close()
+
+	o := &options{}
+	WithAddress(s.Listener.Addr().String())(o)
+	WithTLS(writeTLSFiles(t, t.TempDir(), ca, newTestCert(t, "client", ca)))(o)
+
+	client, err := o.consulClient()
+	assert.NoError(t, err)
+
+	leader, err := client.Status().Leader()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8300", leader)
+}
+
+func TestNewReturnsErrorWhenClientAndTLS(t *testing.T) {
+	assertNewReturnsError(t, "WithClient can not be combined with WithTLS", WithClient(&api.Client{}), WithTLS(ConsulTLSConfig{}))
+}
+
+func TestNewDoesNotModifyConfigWithTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil)
+	conf := &api.Config{Address: "localhost:8500", Scheme: "http"}
+
+	o := &options{}
+	WithConfig(conf)(o)
+	WithTLS(writeTLSFiles(t, t.TempDir(), ca, newTestCert(t, "client", ca)))(o)
+
+	_, err := o.consulClient()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "http", conf.Scheme)
+	assert.Nil(t, conf.HttpClient)
+}
+
+func TestNewReturnsErrorWhenConfigTLSAndTLS(t *testing.T) {
+	conf := &api.Config{TLSConfig: api.TLSConfig{CAFile: "/ca.pem"}}
+
+	assertNewReturnsError(t, "WithTLS can not be combined with a config which sets TLSConfig", WithConfig(conf), WithTLS(ConsulTLSConfig{}))
+}
+
+func TestNewReturnsErrorWhenConfigHttpClientAndTLS(t *testing.T) {
+	conf := &api.Config{HttpClient: &http.Client{}}
+
+	assertNewReturnsError(t, "WithTLS can not be combined with a config which sets HttpClient", WithConfig(conf), WithTLS(ConsulTLSConfig{}))
+}
+
+func TestNewReturnsErrorForInvalidTLSFiles(t *testing.T) {
+	assertNewReturnsError(t, "Unable to configure Consul TLS", WithTLS(ConsulTLSConfig{CAFile: "/does/not/exist"}))
+}
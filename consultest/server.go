@@ -0,0 +1,653 @@
+// Package consultest provides an in-process fake of the Consul HTTP API which
+// can be used to test applications using the resolver without a Consul agent.
+//
+// The server emulates the health, catalog, prepared query, config entry,
+// Connect CA roots and agent service endpoints. Blocking queries are supported using the
+// index and wait parameters, a query blocks until the state of the server
+// changes or the wait time expires.
+//
+// example usage:
+//
+//	s := consultest.NewServer()
+//	defer s.Close()
+//
+//	s.RegisterService(&api.AgentService{ID: "web-1", Service: "web", Port: 8080})
+//
+//	r := resolver.NewResolver(catalog.NewServiceQuery(s.Client(), false))
+package consultest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// DefaultTrustDomain is the Connect CA trust domain returned by the server
+const DefaultTrustDomain = "11111111-2222-3333-4444-555555555555.consul"
+
+// Server is a fake Consul HTTP API server
+type Server struct {
+	server      *httptest.Server
+	mutex       sync.Mutex
+	index       uint64
+	changed     chan struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+	datacenter  string
+	node        *api.Node
+	trustDomain string
+	services    map[string]*serviceInstance
+	queries     map[string]*api.PreparedQueryDefinition
+	config      map[string]interface{}
+	requests    map[string]int
+}
+
+type serviceInstance struct {
+	node    *api.Node
+	service *api.AgentService
+	status  string
+}
+
+// NewServer creates and starts a new fake Consul server for the datacenter
+// dc1, services are registered on a single node with the address 127.0.0.1
+// unless registered with RegisterNodeService
+func NewServer() *Server {
+	s := &Server{
+		index:       1,
+		changed:     make(chan struct{}),
+		closed:      make(chan struct{}),
+		datacenter:  "dc1",
+		trustDomain: DefaultTrustDomain,
+		services:    make(map[string]*serviceInstance),
+		queries:     make(map[string]*api.PreparedQueryDefinition),
+		config:      make(map[string]interface{}),
+		requests:    make(map[string]int),
+	}
+
+	s.node = &api.Node{
+		ID:         "00000000-0000-0000-0000-000000000001",
+		Node:       "node1",
+		Address:    "127.0.0.1",
+		Datacenter: s.datacenter,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status/leader", s.handleLeader)
+	mux.HandleFunc("/v1/health/service/", s.handleHealth(false))
+	mux.HandleFunc("/v1/health/connect/", s.handleHealth(true))
+	mux.HandleFunc("/v1/catalog/services", s.handleCatalogServices)
+	mux.HandleFunc("/v1/catalog/service/", s.handleCatalogService)
+	mux.HandleFunc("/v1/query", s.handleQuery)
+	mux.HandleFunc("/v1/query/", s.handleQuery)
+	mux.HandleFunc("/v1/config/", s.handleConfig)
+	mux.HandleFunc("/v1/agent/connect/ca/roots", s.handleCARoots)
+	mux.HandleFunc("/v1/connect/ca/roots", s.handleCARoots)
+	mux.HandleFunc("/v1/agent/self", s.handleAgentSelf)
+	mux.HandleFunc("/v1/agent/services", s.handleAgentServices)
+	mux.HandleFunc("/v1/agent/service/register", s.handleAgentRegister)
+	mux.HandleFunc("/v1/agent/service/deregister/", s.handleAgentDeregister)
+	mux.HandleFunc("/v1/agent/service/", s.handleAgentService)
+
+	s.server = httptest.NewServer(s.countRequests(mux))
+
+	return s
+}
+
+// Close shuts down the server, blocking queries which are waiting for a
+// change return an error so that Close does not wait for their wait time
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.server.Close()
+}
+
+// Addr returns the host and port of the server, this can be used as the
+// Address in the Consul API config
+func (s *Server) Addr() string {
+	return strings.TrimPrefix(s.server.URL, "http://")
+}
+
+// Client returns a Consul API client configured to use the server
+func (s *Server) Client() *api.Client {
+	conf := api.DefaultConfig()
+	conf.Address = s.Addr()
+
+	// NewClient only returns an error for invalid TLS configuration
+	c, _ := api.NewClient(conf)
+	return c
+}
+
+// Node returns the default node services are registered on
+func (s *Server) Node() *api.Node {
+	n := *s.node
+	return &n
+}
+
+// Index returns the current index of the server, the index is incremented
+// for every change in state
+func (s *Server) Index() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.index
+}
+
+// Requests returns the number of requests received for the given path
+func (s *Server) Requests(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests[path]
+}
+
+// SetTrustDomain sets the trust domain returned by the Connect CA roots endpoints
+func (s *Server) SetTrustDomain(trustDomain string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.trustDomain = trustDomain
+	s.notify()
+}
+
+// RegisterService registers a service on the default node with a passing
+// health check, an existing service with the same ID is replaced
+func (s *Server) RegisterService(svc *api.AgentService) {
+	s.RegisterNodeService(nil, svc)
+}
+
+// RegisterNodeService registers a service on the given node with a passing
+// health check, when node is nil the default node is used
+func (s *Server) RegisterNodeService(node *api.Node, svc *api.AgentService) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if node == nil {
+		node = s.node
+	}
+
+	n := *node
+	if n.Datacenter == "" {
+		n.Datacenter = s.datacenter
+	}
+
+	if svc.ID == "" {
+		svc.ID = svc.Service
+	}
+
+	s.services[svc.ID] = &serviceInstance{node: &n, service: svc, status: api.HealthPassing}
+	s.notify()
+}
+
+// SetStatus sets the health status of the service with the given ID, status
+// is one of api.HealthPassing, api.HealthWarning, api.HealthCritical or
+// api.HealthMaint
+func (s *Server) SetStatus(serviceID, status string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	si, ok := s.services[serviceID]
+	if !ok {
+		return fmt.Errorf("Service %s is not registered", serviceID)
+	}
+
+	si.status = status
+	s.notify()
+
+	return nil
+}
+
+// DeregisterService removes the service with the given ID
+func (s *Server) DeregisterService(serviceID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.services, serviceID)
+	s.notify()
+}
+
+// SetConfigEntry sets the config entry of the given kind for the service, the
+// entry is returned as JSON by the config endpoint
+func (s *Server) SetConfigEntry(kind, name string, entry interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.config[kind+"/"+name] = entry
+	s.notify()
+}
+
+// DeleteConfigEntry removes the config entry of the given kind for the service
+func (s *Server) DeleteConfigEntry(kind, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.config, kind+"/"+name)
+	s.notify()
+}
+
+// notify increments the index and wakes any blocking queries, the caller
+// must hold the mutex
+func (s *Server) notify() {
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests[r.URL.Path]++
+		s.mutex.Unlock()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// blockingQuery waits until the index of the server is greater than the index
+// in the request or the wait time expires, the result of f is then written
+// to the response along with the query meta headers
+func (s *Server) blockingQuery(w http.ResponseWriter, r *http.Request, f func() (interface{}, error)) {
+	q := r.URL.Query()
+
+	if dc := q.Get("dc"); dc != "" && dc != s.datacenter {
+		http.Error(w, "No path to datacenter", http.StatusInternalServerError)
+		return
+	}
+
+	index, _ := strconv.ParseUint(q.Get("index"), 10, 64)
+
+	wait := 5 * time.Minute
+	if d, err := time.ParseDuration(q.Get("wait")); err == nil {
+		wait = d
+	}
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+
+	for {
+		s.mutex.Lock()
+		if index == 0 || s.index > index {
+			// the mutex is held until the result has been built
+			break
+		}
+
+		changed := s.changed
+		s.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			// return the current state when the wait time expires
+			index = 0
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			http.Error(w, "Server closed", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	out, err := f()
+	current := s.index
+	s.mutex.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(current, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+
+	if _, ok := q["cached"]; ok {
+		w.Header().Set("X-Cache", "MISS")
+		w.Header().Set("Age", "0")
+	}
+
+	writeJSON(w, out)
+}
+
+func writeJSON(w http.ResponseWriter, out interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (s *Server) handleLeader(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, "127.0.0.1:8300")
+}
+
+func (s *Server) handleHealth(connect bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		q := r.URL.Query()
+		_, passing := q["passing"]
+
+		s.blockingQuery(w, r, func() (interface{}, error) {
+			return s.serviceEntries(name, q["tag"], passing, connect), nil
+		})
+	}
+}
+
+// serviceEntries returns the health entries for the named service, the
+// caller must hold the mutex
+func (s *Server) serviceEntries(name string, tags []string, passingOnly, connect bool) []*api.ServiceEntry {
+	out := make([]*api.ServiceEntry, 0)
+
+	for _, si := range s.services {
+		if !si.matches(name, tags, connect) {
+			continue
+		}
+
+		if passingOnly && si.status != api.HealthPassing {
+			continue
+		}
+
+		out = append(out, si.entry())
+	}
+
+	return out
+}
+
+// matches returns true if the instance provides the named service, when
+// connect is true only Connect proxies for the service and Connect native
+// services are matched
+func (si *serviceInstance) matches(name string, tags []string, connect bool) bool {
+	svc := si.service
+
+	if connect {
+		switch {
+		case svc.Kind == api.ServiceKindConnectProxy && svc.Proxy != nil:
+			if svc.Proxy.DestinationServiceName != name {
+				return false
+			}
+		case svc.Kind == api.ServiceKindConnectProxy:
+			if svc.ProxyDestination != name {
+				return false
+			}
+		case svc.Connect != nil && svc.Connect.Native:
+			if svc.Service != name {
+				return false
+			}
+		default:
+			return false
+		}
+	} else if svc.Service != name {
+		return false
+	}
+
+	for _, t := range tags {
+		found := false
+		for _, st := range svc.Tags {
+			if t == st {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (si *serviceInstance) entry() *api.ServiceEntry {
+	node := *si.node
+	svc := *si.service
+
+	check := &api.HealthCheck{
+		Node:        node.Node,
+		CheckID:     "service:" + svc.ID,
+		Name:        "Service '" + svc.Service + "' check",
+		Status:      si.status,
+		ServiceID:   svc.ID,
+		ServiceName: svc.Service,
+	}
+
+	// maintenance mode is represented by a critical check with a special ID
+	if si.status == api.HealthMaint {
+		check.CheckID = api.ServiceMaintPrefix + svc.ID
+		check.Status = api.HealthCritical
+	}
+
+	return &api.ServiceEntry{
+		Node:    &node,
+		Service: &svc,
+		Checks: api.HealthChecks{
+			&api.HealthCheck{
+				Node:    node.Node,
+				CheckID: "serfHealth",
+				Name:    "Serf Health Status",
+				Status:  api.HealthPassing,
+			},
+			check,
+		},
+	}
+}
+
+func (s *Server) handleCatalogServices(w http.ResponseWriter, r *http.Request) {
+	s.blockingQuery(w, r, func() (interface{}, error) {
+		out := make(map[string][]string)
+		for _, si := range s.services {
+			out[si.service.Service] = append(out[si.service.Service], si.service.Tags...)
+		}
+
+		return out, nil
+	})
+}
+
+func (s *Server) handleCatalogService(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	tags := r.URL.Query()["tag"]
+
+	s.blockingQuery(w, r, func() (interface{}, error) {
+		out := make([]*api.CatalogService, 0)
+
+		for _, si := range s.services {
+			if !si.matches(name, tags, false) {
+				continue
+			}
+
+			out = append(out, &api.CatalogService{
+				ID:              si.node.ID,
+				Node:            si.node.Node,
+				Address:         si.node.Address,
+				Datacenter:      si.node.Datacenter,
+				TaggedAddresses: si.node.TaggedAddresses,
+				NodeMeta:        si.node.Meta,
+				ServiceID:       si.service.ID,
+				ServiceName:     si.service.Service,
+				ServiceAddress:  si.service.Address,
+				ServiceTags:     si.service.Tags,
+				ServiceMeta:     si.service.Meta,
+				ServicePort:     si.service.Port,
+				ServiceWeights: api.Weights{
+					Passing: si.service.Weights.Passing,
+					Warning: si.service.Weights.Warning,
+				},
+				ServiceProxy: si.service.Proxy,
+			})
+		}
+
+		return out, nil
+	})
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/query"), "/"), "/")
+
+	switch {
+	case parts[0] == "" && r.Method == http.MethodPost:
+		def := &api.PreparedQueryDefinition{}
+		if err := json.NewDecoder(r.Body).Decode(def); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.mutex.Lock()
+		def.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", s.index)
+		s.queries[def.ID] = def
+		s.notify()
+		s.mutex.Unlock()
+
+		writeJSON(w, map[string]string{"ID": def.ID})
+	case parts[0] == "":
+		s.blockingQuery(w, r, func() (interface{}, error) {
+			out := make([]*api.PreparedQueryDefinition, 0)
+			for _, q := range s.queries {
+				out = append(out, q)
+			}
+
+			return out, nil
+		})
+	case r.Method == http.MethodDelete:
+		s.mutex.Lock()
+		delete(s.queries, parts[0])
+		s.notify()
+		s.mutex.Unlock()
+	case len(parts) == 2 && parts[1] == "execute":
+		s.blockingQuery(w, r, func() (interface{}, error) {
+			return s.executeQuery(parts[0])
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// executeQuery runs the prepared query with the given ID or name, the caller
+// must hold the mutex
+func (s *Server) executeQuery(idOrName string) (interface{}, error) {
+	for _, q := range s.queries {
+		if q.ID != idOrName && q.Name != idOrName {
+			continue
+		}
+
+		nodes := make([]api.ServiceEntry, 0)
+		for _, si := range s.services {
+			if !si.matches(q.Service.Service, q.Service.Tags, q.Service.Connect) {
+				continue
+			}
+
+			// prepared queries never return critical instances
+			if si.status == api.HealthCritical || si.status == api.HealthMaint {
+				continue
+			}
+
+			if q.Service.OnlyPassing && si.status != api.HealthPassing {
+				continue
+			}
+
+			nodes = append(nodes, *si.entry())
+		}
+
+		return &api.PreparedQueryExecuteResponse{
+			Service:    q.Service.Service,
+			Nodes:      nodes,
+			Datacenter: s.datacenter,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Query not found")
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/config/")
+
+	s.blockingQuery(w, r, func() (interface{}, error) {
+		entry, ok := s.config[key]
+		if !ok {
+			return nil, fmt.Errorf("Config entry not found for %q", key)
+		}
+
+		return entry, nil
+	})
+}
+
+func (s *Server) handleCARoots(w http.ResponseWriter, r *http.Request) {
+	s.blockingQuery(w, r, func() (interface{}, error) {
+		return &api.CARootList{
+			ActiveRootID: "root-1",
+			TrustDomain:  s.trustDomain,
+			Roots:        []*api.CARoot{&api.CARoot{ID: "root-1", Name: "Fake CA Root", Active: true}},
+		}, nil
+	})
+}
+
+func (s *Server) handleAgentSelf(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]map[string]interface{}{
+		"Config": map[string]interface{}{
+			"Datacenter": s.datacenter,
+			"NodeName":   s.node.Node,
+			"NodeID":     s.node.ID,
+		},
+		"Member": map[string]interface{}{
+			"Name": s.node.Node,
+			"Addr": s.node.Address,
+		},
+	})
+}
+
+func (s *Server) handleAgentServices(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	out := make(map[string]*api.AgentService)
+	for id, si := range s.services {
+		out[id] = si.service
+	}
+	s.mutex.Unlock()
+
+	writeJSON(w, out)
+}
+
+func (s *Server) handleAgentService(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/")
+
+	s.blockingQuery(w, r, func() (interface{}, error) {
+		si, ok := s.services[id]
+		if !ok {
+			return nil, fmt.Errorf("Unknown service ID %s", id)
+		}
+
+		return si.service, nil
+	})
+}
+
+func (s *Server) handleAgentRegister(w http.ResponseWriter, r *http.Request) {
+	reg := &api.AgentServiceRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(reg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	svc := &api.AgentService{
+		Kind:              reg.Kind,
+		ID:                reg.ID,
+		Service:           reg.Name,
+		Tags:              reg.Tags,
+		Meta:              reg.Meta,
+		Port:              reg.Port,
+		Address:           reg.Address,
+		EnableTagOverride: reg.EnableTagOverride,
+		ProxyDestination:  reg.ProxyDestination,
+		Proxy:             reg.Proxy,
+	}
+
+	if reg.Weights != nil {
+		svc.Weights = *reg.Weights
+	}
+
+	if reg.Connect != nil {
+		svc.Connect = &api.AgentServiceConnect{Native: reg.Connect.Native}
+	}
+
+	s.RegisterService(svc)
+}
+
+func (s *Server) handleAgentDeregister(w http.ResponseWriter, r *http.Request) {
+	s.DeregisterService(strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+}
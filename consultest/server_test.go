@@ -0,0 +1,250 @@
+package consultest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupServer(t *testing.T) (*Server, *api.Client) {
+	s := NewServer()
+
+	s.RegisterService(&api.AgentService{ID: "web-1", Service: "web", Port: 8080, Tags: []string{"v1"}})
+	s.RegisterService(&api.AgentService{ID: "web-2", Service: "web", Port: 8081, Tags: []string{"v2"}})
+
+	return s, s.Client()
+}
+
+func TestHealthServiceReturnsEntries(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+
+	entries, meta, err := c.Health().Service("web", "", true, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, s.Index(), meta.LastIndex)
+	assert.Equal(t, "127.0.0.1", entries[0].Node.Address)
+	assert.Equal(t, api.HealthPassing, entries[0].Checks.AggregatedStatus())
+}
+
+func TestHealthServiceFiltersByTag(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+
+	entries, _, err := c.Health().Service("web", "v2", false, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 8081, entries[0].Service.Port)
+}
+
+func TestHealthServiceFiltersPassing(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+	s.SetStatus("web-1", api.HealthWarning)
+
+	passing, _, _ := c.Health().Service("web", "", true, nil)
+	all, _, _ := c.Health().Service("web", "", false, nil)
+
+	assert.Len(t, passing, 1)
+	assert.Len(t, all, 2)
+}
+
+func TestHealthServiceReturnsErrorForUnknownDatacenter(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+
+	_, _, err := c.Health().Service("web", "", true, &api.QueryOptions{Datacenter: "dc2"})
+
+	assert.Error(t, err)
+}
+
+func TestHealthServiceMaintenanceIsReportedAsMaintenance(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+	s.SetStatus("web-1", api.HealthMaint)
+
+	entries, _, _ := c.Health().Service("web", "v1", false, nil)
+
+	assert.Equal(t, api.HealthMaint, entries[0].Checks.AggregatedStatus())
+}
+
+func TestBlockingQueryReturnsWhenStateChanges(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		s.DeregisterService("web-2")
+	})
+
+	start := time.Now()
+	entries, meta, err := c.Health().Service("web", "", true, &api.QueryOptions{WaitIndex: s.Index()})
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, s.Index(), meta.LastIndex)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond, "Query should have blocked")
+}
+
+func TestBlockingQueryReturnsWhenWaitExpires(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+
+	entries, _, err := c.Health().Service("web", "", true, &api.QueryOptions{
+		WaitIndex: s.Index(),
+		WaitTime:  50 * time.Millisecond,
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestCloseReturnsBlockingQueries(t *testing.T) {
+	s, c := setupServer(t)
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := c.Health().Service("web", "", true, &api.QueryOptions{WaitIndex: s.Index()})
+		errs <- err
+	}()
+
+	// wait for the query to block
+	assert.Eventually(t, func() bool { return s.Requests("/v1/health/service/web") == 1 }, time.Second, time.Millisecond)
+
+	start := time.Now()
+	s.Close()
+
+	assert.True(t, time.Since(start) < time.Second, "Close should not wait for blocking queries")
+	assert.Error(t, <-errs)
+}
+
+func TestHealthConnectReturnsProxies(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+	s.RegisterService(&api.AgentService{
+		ID:      "web-1-proxy",
+		Service: "web-proxy",
+		Kind:    api.ServiceKindConnectProxy,
+		Port:    9090,
+		Proxy:   &api.AgentServiceConnectProxyConfig{DestinationServiceName: "web"},
+	})
+
+	entries, _, err := c.Health().Connect("web", "", true, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 9090, entries[0].Service.Port)
+}
+
+func TestAgentRegisterAndDeregister(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+
+	err := c.Agent().ServiceRegister(&api.AgentServiceRegistration{ID: "api-1", Name: "api", Port: 7000})
+	assert.NoError(t, err)
+
+	entries, _, _ := c.Health().Service("api", "", true, nil)
+	assert.Len(t, entries, 1)
+
+	err = c.Agent().ServiceDeregister("api-1")
+	assert.NoError(t, err)
+
+	entries, _, _ = c.Health().Service("api", "", true, nil)
+	assert.Len(t, entries, 0)
+}
+
+func TestPreparedQueryExecute(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+	s.SetStatus("web-2", api.HealthCritical)
+
+	id, _, err := c.PreparedQuery().Create(&api.PreparedQueryDefinition{
+		Name:    "web-query",
+		Service: api.ServiceQuery{Service: "web"},
+	}, nil)
+	assert.NoError(t, err)
+
+	resp, _, err := c.PreparedQuery().Execute("web-query", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, resp.Nodes, 1)
+
+	_, err = c.PreparedQuery().Delete(id, nil)
+	assert.NoError(t, err)
+
+	_, _, err = c.PreparedQuery().Execute("web-query", nil)
+	assert.Error(t, err)
+}
+
+func TestConnectCARootsReturnsTrustDomain(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+	s.SetTrustDomain("abc.com")
+
+	roots, _, err := c.Agent().ConnectCARoots(nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "abc.com", roots.TrustDomain)
+}
+
+func TestServiceQueryResolvesConnectServices(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+	s.RegisterService(&api.AgentService{
+		ID:      "web-1-proxy",
+		Service: "web-proxy",
+		Kind:    api.ServiceKindConnectProxy,
+		Port:    9090,
+		Proxy:   &api.AgentServiceConnectProxyConfig{DestinationServiceName: "web"},
+	})
+
+	entries, err := catalog.NewServiceQuery(c, true).Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "127.0.0.1:9090", entries[0].Addr)
+	assert.Equal(t,
+		"spiffe://"+DefaultTrustDomain+"/ns/default/dc/dc1/svc/web",
+		entries[0].CertURI.URI().String(),
+	)
+}
+
+func TestRequestsCountsRequests(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+
+	c.Health().Service("web", "", true, nil)
+	c.Health().Service("web", "", true, nil)
+
+	assert.Equal(t, 2, s.Requests("/v1/health/service/web"))
+}
+
+func TestDiscoveryChainQueryReadsConfigEntries(t *testing.T) {
+	s, c := setupServer(t)
+	defer s.Close()
+	s.SetConfigEntry(catalog.ServiceResolver, "web", &catalog.ServiceResolverConfigEntry{
+		Kind:          catalog.ServiceResolver,
+		Name:          "web",
+		DefaultSubset: "v2",
+		Subsets: map[string]catalog.ServiceResolverSubset{
+			"v2": catalog.ServiceResolverSubset{Filter: "v2 in Service.Tags"},
+		},
+	})
+
+	entries, err := catalog.NewDiscoveryChainQuery(c, false).Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "127.0.0.1:8081", entries[0].Addr)
+
+	s.DeleteConfigEntry(catalog.ServiceResolver, "web")
+
+	entries, err = catalog.NewDiscoveryChainQuery(c, false).Execute("web", nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
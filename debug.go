@@ -0,0 +1,203 @@
+package resolver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+)
+
+// DebugHandler returns a http.Handler which reports the state of the watchers
+// for each target of the given resolvers. The state is rendered as a HTML page
+// or as JSON when the request has the query parameter format=json or accepts
+// application/json.
+// example usage:
+// r := resolver.NewServiceQueryResolver("http://consulAddr:8500")
+// http.Handle("/debug/resolver", resolver.DebugHandler(r))
+func DebugHandler(resolvers ...*ConsulResolver) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		state := make([]debugResolver, 0, len(resolvers))
+		for _, cr := range resolvers {
+			state = append(state, cr.debugState())
+		}
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			rw.Header().Set("Content-Type", "application/json")
+
+			enc := json.NewEncoder(rw)
+			enc.SetIndent("", "  ")
+			enc.Encode(state)
+
+			return
+		}
+
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		debugTemplate.Execute(rw, state)
+	})
+}
+
+type debugResolver struct {
+	Backend string        `json:"backend"`
+	Targets []debugTarget `json:"targets"`
+}
+
+type debugTarget struct {
+	Target string `json:"target"`
+	// Source is grpc for targets resolved by a ClientConn or subscription
+	// for targets watched by Subscribe
+	Source      string          `json:"source"`
+	Endpoints   []debugEndpoint `json:"endpoints"`
+	LastQuery   *time.Time      `json:"last_query,omitempty"`
+	LastSuccess *time.Time      `json:"last_success,omitempty"`
+	LastIndex   uint64          `json:"last_index,omitempty"`
+	// StalenessMs is the age in milliseconds of the last result, only known
+	// for queries which record the Consul QueryMeta
+	StalenessMs int64 `json:"staleness_ms,omitempty"`
+	// Panic is true while all registered instances are returned because too
+	// few instances are healthy
+	Panic bool `json:"panic,omitempty"`
+	// PollIntervalMs is the interval between queries, when adaptive polling
+	// is enabled this is the current interval or the minimum interval after a
+	// change
+	PollIntervalMs int64  `json:"poll_interval_ms"`
+	Failures       int    `json:"consecutive_failures"`
+	LastError      string `json:"last_error,omitempty"`
+}
+
+type debugEndpoint struct {
+	Address string   `json:"address"`
+	CertURI string   `json:"cert_uri,omitempty"`
+	Weight  int      `json:"weight"`
+	SNI     string   `json:"sni,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	// Draining is true for endpoints which have been removed and are waiting
+	// for their drain period to expire
+	Draining bool `json:"draining"`
+}
+
+// debugState returns the state of the watchers for each target
+func (g *ConsulResolver) debugState() debugResolver {
+	dr := debugResolver{Backend: queryBackend(g.query), Targets: make([]debugTarget, 0)}
+
+	for target, w := range g.watcherList() {
+		dr.Targets = append(dr.Targets, w.debugState(target, "grpc"))
+	}
+
+	for target, w := range g.subscribedList() {
+		dr.Targets = append(dr.Targets, w.debugState(target, "subscription"))
+	}
+
+	sort.Slice(dr.Targets, func(i, j int) bool {
+		if dr.Targets[i].Target == dr.Targets[j].Target {
+			return dr.Targets[i].Source < dr.Targets[j].Source
+		}
+
+		return dr.Targets[i].Target < dr.Targets[j].Target
+	})
+
+	return dr
+}
+
+// debugState returns the state of the watcher
+func (c *ConsulWatcher) debugState(target, source string) debugTarget {
+	dt := debugTarget{
+		Target:      target,
+		Source:      source,
+		Endpoints:   make([]debugEndpoint, 0),
+		LastQuery:   unixTime(atomic.LoadInt64(&c.lastQuery)),
+		LastSuccess: unixTime(atomic.LoadInt64(&c.lastSuccess)),
+	}
+
+	interval := c.update
+	if c.Adaptive {
+		interval = time.Duration(atomic.LoadInt64(&c.current))
+		if interval == 0 {
+			interval, _ = c.bounds()
+		}
+	}
+	dt.PollIntervalMs = int64(interval / time.Millisecond)
+
+	failures, err := c.queryError()
+	dt.Failures = failures
+	if err != nil {
+		dt.LastError = err.Error()
+	}
+
+	if mr, ok := c.query.(catalog.MetaReporter); ok {
+		if meta := mr.LastMeta(c.service); meta != nil {
+			dt.LastIndex = meta.LastIndex
+			dt.StalenessMs = int64((meta.LastContact + meta.CacheAge) / time.Millisecond)
+		}
+	}
+
+	if pr, ok := c.query.(catalog.PanicReporter); ok {
+		dt.Panic = pr.InPanic(c.service)
+	}
+
+	for _, se := range c.endpoints() {
+		dt.Endpoints = append(dt.Endpoints, newDebugEndpoint(se, false))
+	}
+
+	for _, se := range c.drainingEndpoints() {
+		dt.Endpoints = append(dt.Endpoints, newDebugEndpoint(se, true))
+	}
+
+	sort.Slice(dt.Endpoints, func(i, j int) bool { return dt.Endpoints[i].Address < dt.Endpoints[j].Address })
+
+	return dt
+}
+
+// newDebugEndpoint returns the debug state of the endpoint
+func newDebugEndpoint(se catalog.ServiceEntry, draining bool) debugEndpoint {
+	de := debugEndpoint{Address: se.Addr, Weight: se.Weight, SNI: se.SNI, Tags: se.Tags, Draining: draining}
+	if se.CertURI != nil {
+		de.CertURI = se.CertURI.URI().String()
+	}
+
+	return de
+}
+
+// unixTime converts unix nanoseconds to a time, zero is returned as nil
+func unixTime(ns int64) *time.Time {
+	if ns == 0 {
+		return nil
+	}
+
+	t := time.Unix(0, ns).UTC()
+	return &t
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head><title>gRPC Consul resolver</title></head>
+<body>
+{{range .}}
+<h1>Resolver ({{.Backend}})</h1>
+{{range .Targets}}
+<h2>{{.Target}} <small>{{.Source}}</small></h2>
+<table>
+<tr><td>Last query</td><td>{{with .LastQuery}}{{.}}{{else}}never{{end}}</td></tr>
+<tr><td>Last success</td><td>{{with .LastSuccess}}{{.}}{{else}}never{{end}}</td></tr>
+<tr><td>Last index</td><td>{{.LastIndex}}</td></tr>
+<tr><td>Staleness</td><td>{{.StalenessMs}}ms</td></tr>
+<tr><td>Panic</td><td>{{.Panic}}</td></tr>
+<tr><td>Poll interval</td><td>{{.PollIntervalMs}}ms</td></tr>
+<tr><td>Consecutive failures</td><td>{{.Failures}}</td></tr>
+<tr><td>Last error</td><td>{{.LastError}}</td></tr>
+</table>
+<table border="1">
+<tr><th>Address</th><th>CertURI</th><th>Weight</th><th>SNI</th><th>Tags</th><th>Draining</th></tr>
+{{range .Endpoints}}<tr><td>{{.Address}}</td><td>{{.CertURI}}</td><td>{{.Weight}}</td><td>{{.SNI}}</td><td>{{range .Tags}}{{.}} {{end}}</td><td>{{.Draining}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No targets</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,143 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupDebugHandler(t *testing.T) *ConsulResolver {
+	ses = []catalog.ServiceEntry{
+		catalog.ServiceEntry{
+			Addr:   "localhost:8080",
+			Weight: 2,
+			Tags:   []string{"v1"},
+			CertURI: &connect.SpiffeIDService{
+				Host:       "abc.com",
+				Namespace:  "default",
+				Datacenter: "dc1",
+				Service:    "web",
+			},
+		},
+	}
+
+	queryMock = &catalog.MockQuery{}
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(getServices, nil)
+
+	r := NewResolver(queryMock)
+	w, _ := r.Resolve("web")
+	w.Next()
+
+	return r
+}
+
+func TestDebugHandlerReturnsJSON(t *testing.T) {
+	r := setupDebugHandler(t)
+
+	rr := httptest.NewRecorder()
+	DebugHandler(r).ServeHTTP(rr, httptest.NewRequest("GET", "/?format=json", nil))
+
+	state := []debugResolver{}
+	err := json.Unmarshal(rr.Body.Bytes(), &state)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Len(t, state, 1)
+	assert.Equal(t, "mock", state[0].Backend)
+	assert.Len(t, state[0].Targets, 1)
+
+	target := state[0].Targets[0]
+	assert.Equal(t, "web", target.Target)
+	assert.Equal(t, "grpc", target.Source)
+	assert.NotNil(t, target.LastQuery)
+	assert.NotNil(t, target.LastSuccess)
+	assert.Equal(t, 0, target.Failures)
+	assert.Len(t, target.Endpoints, 1)
+	assert.Equal(t, "localhost:8080", target.Endpoints[0].Address)
+	assert.Equal(t, "spiffe://abc.com/ns/default/dc/dc1/svc/web", target.Endpoints[0].CertURI)
+	assert.Equal(t, 2, target.Endpoints[0].Weight)
+	assert.Equal(t, []string{"v1"}, target.Endpoints[0].Tags)
+}
+
+func TestDebugHandlerReturnsJSONWhenAccepted(t *testing.T) {
+	r := setupDebugHandler(t)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	DebugHandler(r).ServeHTTP(rr, req)
+
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+}
+
+func TestDebugHandlerReportsQueryErrors(t *testing.T) {
+	r := setupDebugHandler(t)
+	queryMock.ExpectedCalls = make([]*mock.Call, 0)
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Boom"))
+
+	w := r.watcherList()["web"]
+	w.Next()
+	w.Next()
+
+	target := r.debugState().Targets[0]
+
+	assert.Equal(t, 2, target.Failures)
+	assert.Equal(t, "Boom", target.LastError)
+	assert.Len(t, target.Endpoints, 1, "Endpoints should be retained after an error")
+}
+
+func TestDebugHandlerReportsSubscriptions(t *testing.T) {
+	r := setupSubscription(t)
+	s := r.Subscribe("test", SubscribeOptions{})
+	defer s.Close()
+
+	targets := r.debugState().Targets
+
+	assert.Len(t, targets, 1)
+	assert.Equal(t, "subscription", targets[0].Source)
+}
+
+func TestDebugHandlerReturnsHTML(t *testing.T) {
+	r := setupDebugHandler(t)
+
+	rr := httptest.NewRecorder()
+	DebugHandler(r).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "<h2>web <small>grpc</small></h2>")
+	assert.Contains(t, rr.Body.String(), "spiffe://abc.com/ns/default/dc/dc1/svc/web")
+}
+
+func TestDebugHandlerReportsMinimumIntervalBeforeFirstChange(t *testing.T) {
+	r := setupDebugHandler(t)
+	w := r.watcherList()["web"]
+	w.Adaptive = true
+	w.MinInterval = 2 * time.Second
+	w.resetInterval()
+
+	target := r.debugState().Targets[0]
+
+	assert.Equal(t, int64(2000), target.PollIntervalMs)
+}
+
+func TestDebugHandlerReportsDrainingEndpoints(t *testing.T) {
+	r := setupDebugHandler(t)
+	w := r.watcherList()["web"]
+	w.Weighted = true
+	w.DrainPeriod = time.Hour
+	ses = make([]catalog.ServiceEntry, 0)
+	w.Next()
+
+	target := r.debugState().Targets[0]
+
+	assert.Len(t, target.Endpoints, 1)
+	assert.Equal(t, "localhost:8080", target.Endpoints[0].Address)
+	assert.True(t, target.Endpoints[0].Draining)
+}
@@ -0,0 +1,83 @@
+package resolver
+
+import (
+	"time"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"google.golang.org/grpc/naming"
+)
+
+// drainingEntry is an endpoint which has been removed from Consul or entered
+// maintenance and is waiting for its drain period to expire
+type drainingEntry struct {
+	entry    catalog.ServiceEntry
+	deadline time.Time
+}
+
+// startDrain moves the endpoint from the cache to the draining endpoints and
+// returns the update which marks it as draining in the balancer, the caller
+// must hold the cache mutex
+func (c *ConsulWatcher) startDrain(se catalog.ServiceEntry, now time.Time) *naming.Update {
+	catalog.LoggerOrNop(c.Logger).Info("endpoint draining", "target", c.service, "address", se.Addr, "period", c.DrainPeriod)
+
+	delete(c.addressCache, se.Addr)
+	c.draining[se.Addr] = drainingEntry{entry: se, deadline: now.Add(c.DrainPeriod)}
+
+	return &naming.Update{
+		Op:       naming.Add,
+		Addr:     se.Addr,
+		Metadata: Metadata{Weight: se.Weight, Draining: true},
+	}
+}
+
+// expireDrained returns the delete updates for the draining endpoints whose
+// drain period has expired, the caller must hold the cache mutex
+func (c *ConsulWatcher) expireDrained(now time.Time) []*naming.Update {
+	nu := make([]*naming.Update, 0)
+
+	for k, d := range c.draining {
+		if now.Before(d.deadline) {
+			continue
+		}
+
+		catalog.LoggerOrNop(c.Logger).Info("endpoint removed", "target", c.service, "address", k)
+		nu = append(nu, c.newUpdate(naming.Delete, d.entry))
+		delete(c.draining, k)
+		delete(c.added, k)
+	}
+
+	return nu
+}
+
+// drainingEndpoints returns a copy of the endpoints which are draining
+func (c *ConsulWatcher) drainingEndpoints() []catalog.ServiceEntry {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	ses := make([]catalog.ServiceEntry, 0, len(c.draining))
+	for _, d := range c.draining {
+		ses = append(ses, d.entry)
+	}
+
+	return ses
+}
+
+// drainInterval returns the interval until the next query, the interval is
+// shortened so that draining endpoints are deleted when their drain period
+// expires
+func (c *ConsulWatcher) drainInterval(interval time.Duration, now time.Time) time.Duration {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	for _, d := range c.draining {
+		if wait := d.deadline.Sub(now); wait < interval {
+			interval = wait
+		}
+	}
+
+	if interval < 0 {
+		return 0
+	}
+
+	return interval
+}
@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/naming"
+)
+
+func TestNextMarksRemovedItemsAsDraining(t *testing.T) {
+	w := setupWatcher(t)
+	w.Weighted = true
+	w.DrainPeriod = time.Hour
+	ses[0].Weight = 1
+	w.Next()
+	ses = make([]catalog.ServiceEntry, 0)
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 1, "Should have returned 1 update")
+	assert.Equal(t, naming.Add, nu[0].Op)
+	assert.Equal(t, Metadata{Weight: 1, Draining: true}, nu[0].Metadata)
+	assert.Len(t, w.endpoints(), 0)
+
+	_, ok := w.serviceEntry("localhost:8080")
+	assert.True(t, ok, "Draining items should be resolvable")
+}
+
+func TestNextDeletesDrainingItemsWhenPeriodExpires(t *testing.T) {
+	w := setupWatcher(t)
+	w.update = time.Hour
+	w.Weighted = true
+	w.DrainPeriod = 20 * time.Millisecond
+	w.Next()
+	ses = make([]catalog.ServiceEntry, 0)
+	w.Next()
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 1, "Should have returned 1 update")
+	assert.Equal(t, "localhost:8080", nu[0].Addr)
+	assert.Equal(t, naming.Delete, nu[0].Op)
+
+	_, ok := w.serviceEntry("localhost:8080")
+	assert.False(t, ok)
+}
+
+func TestNextAddsDrainingItemsWhichReturn(t *testing.T) {
+	w := setupWatcher(t)
+	w.Weighted = true
+	w.DrainPeriod = time.Hour
+	w.Next()
+	ses = make([]catalog.ServiceEntry, 0)
+	w.Next()
+	ses = append(ses, catalog.ServiceEntry{Addr: "localhost:8080"})
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 1, "Should have returned 1 update")
+	assert.Equal(t, naming.Add, nu[0].Op)
+	assert.Equal(t, Metadata{}, nu[0].Metadata)
+	assert.Len(t, w.draining, 0)
+}
+
+func TestNextDeletesRemovedItemsWhenNotWeighted(t *testing.T) {
+	w := setupWatcher(t)
+	w.DrainPeriod = time.Hour
+	w.Next()
+	ses = make([]catalog.ServiceEntry, 0)
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 1, "Should have returned 1 update")
+	assert.Equal(t, naming.Delete, nu[0].Op)
+	assert.Len(t, w.draining, 0)
+}
+
+func TestRoundRobinRemovesAddressesWhenDrainPeriodSet(t *testing.T) {
+	r := NewResolver(nil)
+	r.DrainPeriod = time.Hour
+	cw := r.newWatcher("test")
+	queryMock = &catalog.MockQuery{}
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(getServices, nil)
+	cw.query = queryMock
+	ses = []catalog.ServiceEntry{catalog.ServiceEntry{Addr: "localhost:8080"}}
+
+	w := &testWatcher{make(chan []*naming.Update, 1)}
+	nu, _ := cw.Next()
+	w.updates <- nu
+
+	b := grpc.RoundRobin(&testResolver{w})
+	b.Start("test", grpc.BalancerConfig{})
+	defer b.Close()
+	assert.Len(t, <-b.Notify(), 1)
+
+	ses = make([]catalog.ServiceEntry, 0)
+	nu, _ = cw.Next()
+	w.updates <- nu
+
+	assert.Len(t, <-b.Notify(), 0, "Removed addresses should be deleted from the RoundRobin balancer")
+}
+
+func TestWeightedRoundRobinDoesNotPickDrainingAddresses(t *testing.T) {
+	w := &testWatcher{make(chan []*naming.Update, 1)}
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080"},
+		&naming.Update{Op: naming.Add, Addr: "localhost:8081"},
+	}
+
+	b := WeightedRoundRobin(&testResolver{w})
+	b.Start("test", grpc.BalancerConfig{})
+	defer b.Close()
+
+	for _, a := range <-b.Notify() {
+		b.Up(a)
+	}
+
+	// start a request so that the address is not idle
+	a, put, err := b.Get(context.Background(), grpc.BalancerGetOptions{})
+	assert.NoError(t, err)
+
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: a.Addr, Metadata: Metadata{Weight: 1, Draining: true}},
+	}
+	assert.Len(t, <-b.Notify(), 2, "Draining addresses should remain connected")
+
+	for i := 0; i < 4; i++ {
+		next, _, _ := b.Get(context.Background(), grpc.BalancerGetOptions{})
+		assert.NotEqual(t, a.Addr, next.Addr)
+	}
+
+	put()
+
+	addrs := <-b.Notify()
+	assert.Len(t, addrs, 1, "Draining addresses should be removed when idle")
+	assert.NotEqual(t, a, addrs[0])
+}
+
+func TestWeightedRoundRobinRemovesIdleDrainingAddresses(t *testing.T) {
+	w := &testWatcher{make(chan []*naming.Update, 1)}
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080"},
+	}
+
+	b := WeightedRoundRobin(&testResolver{w})
+	b.Start("test", grpc.BalancerConfig{})
+	defer b.Close()
+	<-b.Notify()
+
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080", Metadata: Metadata{Weight: 1, Draining: true}},
+	}
+
+	assert.Len(t, <-b.Notify(), 0)
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/hashicorp/consul/connect"
 	resolver "github.com/nicholasjackson/grpc-consul-resolver"
 	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/nicholasjackson/grpc-consul-resolver/consultest"
 	echo "github.com/nicholasjackson/grpc-consul-resolver/functional_tests/grpc"
 	"google.golang.org/grpc"
 )
@@ -30,6 +31,7 @@ var serviceName = "test_grpc"
 var preparedQueryName = "test_grpc_query"
 
 var consulClient *api.Client
+var fakeConsul *consultest.Server
 var grpcClient *grpc.ClientConn
 var connectService *connect.Service
 var echoClient echo.EchoServiceClient
@@ -52,9 +54,15 @@ var gRPCServers map[string]*gRPCServer
 func init() {
 	godog.BindFlags("godog.", flag.CommandLine, &opt)
 
+	// when CONSUL_HTTP_ADDR is not set the tests run against an in-process
+	// fake Consul server, Connect scenarios require a real Consul agent to run
+	// the proxies and are skipped
 	envAddr := os.Getenv("CONSUL_HTTP_ADDR")
 	if envAddr != "" {
 		consulAddr = envAddr
+	} else {
+		fakeConsul = consultest.NewServer()
+		consulAddr = fakeConsul.Addr()
 	}
 
 	envAddr = os.Getenv("BIND_ADDR")
@@ -67,6 +75,12 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 	opt.Paths = flag.Args()
 
+	if fakeConsul != nil {
+		if opt.Tags == "" {
+			opt.Tags = "~@connect"
+		}
+	}
+
 	status := godog.RunWithOptions("godogs", func(s *godog.Suite) {
 		FeatureContext(s)
 	}, opt)
@@ -74,6 +88,11 @@ func TestMain(m *testing.M) {
 	if st := m.Run(); st > status {
 		status = st
 	}
+
+	if fakeConsul != nil {
+		fakeConsul.Close()
+	}
+
 	os.Exit(status)
 }
 
@@ -152,8 +171,11 @@ func runGRPCServer(port int) error {
 		return err
 	}
 
-	// start the proxy
-	cmd := startProxy(serviceName, serviceBind, port)
+	// start the proxy, the fake Consul server does not support Connect
+	var cmd *exec.Cmd
+	if fakeConsul == nil {
+		cmd = startProxy(serviceName, serviceBind, port)
+	}
 
 	gRPCServers[addr] = &gRPCServer{
 		id:           id,
@@ -194,7 +216,9 @@ func stopGRPCServer(s *gRPCServer) {
 	s.socket.Close()
 
 	// stop the proxy
-	s.proxyCommand.Process.Signal(os.Interrupt)
+	if s.proxyCommand != nil && s.proxyCommand.Process != nil {
+		s.proxyCommand.Process.Signal(os.Interrupt)
+	}
 
 	delete(gRPCServers, s.address)
 }
@@ -0,0 +1,126 @@
+package resolver
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by the watchers of a ConsulResolver
+// which bounds the total rate of Consul queries. Initial queries for a target
+// take priority over refreshes, while an initial query is waiting refreshes
+// are not given a token.
+type rateLimiter struct {
+	mutex   sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64
+	tokens  float64
+	last    time.Time
+	initial int // number of waiting initial queries
+
+	now   func() time.Time
+	sleep func(time.Duration, <-chan struct{}) bool
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  sleepUntilDone,
+	}
+}
+
+// wait blocks until a token is available and returns the time spent waiting,
+// 0 is returned when a token was immediately available. initial should be
+// true when the query is the first for the target. false is returned without
+// a token when done is closed while waiting
+func (l *rateLimiter) wait(initial bool, done <-chan struct{}) (time.Duration, bool) {
+	start := l.now()
+	waiting := false
+
+	for {
+		d, ok := l.take(initial, waiting)
+		if ok && !waiting {
+			return 0, true
+		}
+
+		if ok {
+			return l.now().Sub(start), true
+		}
+
+		waiting = true
+		if !l.sleep(d, done) {
+			l.cancel(initial)
+			return l.now().Sub(start), false
+		}
+	}
+}
+
+// cancel removes a waiting query which has stopped waiting for a token
+func (l *rateLimiter) cancel(initial bool) {
+	if !initial {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.initial--
+}
+
+// sleepUntilDone sleeps for the duration and returns true, false is returned
+// as soon as done is closed
+func sleepUntilDone(d time.Duration, done <-chan struct{}) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// take takes a token when available, when not it returns the time until the
+// next token is added, waiting is true when the caller has already been
+// registered as a waiting initial query
+func (l *rateLimiter) take(initial, waiting bool) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 && (initial || l.initial == 0) {
+		l.tokens--
+
+		if initial && waiting {
+			l.initial--
+		}
+
+		return 0, true
+	}
+
+	if initial && !waiting {
+		l.initial++
+	}
+
+	d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+	if d <= 0 {
+		// a token is available but is reserved for an initial query
+		d = time.Duration(float64(time.Second) / l.rate)
+	}
+
+	return d, false
+}
@@ -0,0 +1,164 @@
+package resolver
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// setupLimiter returns a limiter with a fake clock which advances when the
+// limiter sleeps
+func setupLimiter(rate float64, burst int) *rateLimiter {
+	now := time.Unix(0, 0)
+
+	l := newRateLimiter(rate, burst)
+	l.last = now
+	l.now = func() time.Time { return now }
+	l.sleep = func(d time.Duration, done <-chan struct{}) bool {
+		select {
+		case <-done:
+			return false
+		default:
+		}
+
+		now = now.Add(d)
+		return true
+	}
+
+	return l
+}
+
+func TestLimiterAllowsBurst(t *testing.T) {
+	l := setupLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		wait, ok := l.wait(false, nil)
+		assert.True(t, ok)
+		assert.Equal(t, time.Duration(0), wait)
+	}
+}
+
+func TestLimiterWaitsWhenBurstUsed(t *testing.T) {
+	l := setupLimiter(2, 1)
+
+	l.wait(false, nil)
+	wait, ok := l.wait(false, nil)
+
+	assert.True(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+}
+
+func TestLimiterGivesInitialQueriesPriority(t *testing.T) {
+	l := setupLimiter(1, 1)
+	l.wait(false, nil)
+
+	// register an initial query as waiting
+	_, ok := l.take(true, false)
+	assert.False(t, ok)
+	assert.Equal(t, 1, l.initial)
+
+	// after a second a token is available but it is reserved
+	l.sleep(time.Second, nil)
+	_, ok = l.take(false, false)
+	assert.False(t, ok)
+
+	_, ok = l.take(true, true)
+	assert.True(t, ok)
+	assert.Equal(t, 0, l.initial)
+}
+
+func TestLimiterReturnsWhenDone(t *testing.T) {
+	l := setupLimiter(1, 1)
+	l.wait(true, nil)
+
+	done := make(chan struct{})
+	close(done)
+
+	_, ok := l.wait(true, done)
+
+	assert.False(t, ok)
+	assert.Equal(t, 0, l.initial)
+}
+
+func TestLimiterReturnsWhenDoneClosedWhileSleeping(t *testing.T) {
+	l := newRateLimiter(0.001, 1)
+	l.wait(false, nil)
+
+	done := make(chan struct{})
+	time.AfterFunc(10*time.Millisecond, func() { close(done) })
+
+	start := time.Now()
+	_, ok := l.wait(false, done)
+
+	assert.False(t, ok)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestNextReturnsWhenClosedWhileThrottled(t *testing.T) {
+	w := setupWatcher(t)
+	w.limiter = newRateLimiter(0.001, 1)
+	w.limiter.wait(false, nil)
+
+	time.AfterFunc(10*time.Millisecond, w.Close)
+
+	up, err := w.Next()
+
+	assert.Nil(t, err)
+	assert.Nil(t, up)
+	queryMock.AssertNotCalled(t, "Execute", mock.Anything, mock.Anything)
+}
+
+func TestNextIsNotInitialAfterFailedQuery(t *testing.T) {
+	sink := setupMetrics(t)
+	w := setupWatcher(t)
+	w.limiter = setupLimiter(1, 1)
+	queryMock.ExpectedCalls = make([]*mock.Call, 0)
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Boom"))
+
+	w.Next()
+	w.Next()
+
+	data := sink.Data()
+	assert.Equal(t, 1, data[0].Counters["grpc_consul_resolver.query.throttled;target=test;priority=refresh"].Count)
+	assert.NotContains(t, data[0].Counters, "grpc_consul_resolver.query.throttled;target=test;priority=initial")
+}
+
+func TestLimiterIsSharedByWatchers(t *testing.T) {
+	r := NewResolver(nil)
+	r.QueryRate = 10
+
+	w1 := r.newWatcher("a")
+	w2 := r.newWatcher("b")
+
+	assert.NotNil(t, w1.limiter)
+	assert.Equal(t, w1.limiter, w2.limiter)
+}
+
+func TestLimiterIsNotCreatedWithoutRate(t *testing.T) {
+	r := NewResolver(nil)
+
+	w := r.newWatcher("a")
+
+	assert.Nil(t, w.limiter)
+}
+
+func TestLimiterBoundsConcurrentQueries(t *testing.T) {
+	l := newRateLimiter(100, 1)
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l.wait(i%2 == 0, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, time.Since(start) >= 80*time.Millisecond, "10 queries at 100 per second with a burst of 1 should take about 90ms")
+}
@@ -0,0 +1,139 @@
+package resolver
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+)
+
+// Metrics are emitted using the global github.com/armon/go-metrics instance,
+// no metrics are recorded until a sink has been configured with
+// metrics.NewGlobal.
+var (
+	// metricQuery measures the time taken to execute the catalog query
+	metricQuery = []string{"grpc_consul_resolver", "query"}
+	// metricQueryError counts the catalog queries which returned an error
+	metricQueryError = []string{"grpc_consul_resolver", "query", "error"}
+	// metricEndpoints is the number of endpoints resolved for the target
+	metricEndpoints = []string{"grpc_consul_resolver", "endpoints"}
+	// metricUpdate counts the add, delete and drain updates sent to the
+	// balancer
+	metricUpdate = []string{"grpc_consul_resolver", "update"}
+	// metricStaleness is the age in milliseconds of the query result, this is
+	// the time since the servers last contacted the leader plus the age of
+	// the result in the agent cache
+	metricStaleness = []string{"grpc_consul_resolver", "staleness"}
+	// metricThrottled counts the queries delayed by the resolver rate limit
+	metricThrottled = []string{"grpc_consul_resolver", "query", "throttled"}
+	// metricThrottleWait measures the time queries waited for the rate limit
+	metricThrottleWait = []string{"grpc_consul_resolver", "query", "throttle_wait"}
+	// metricPanic is 1 while the query for the target is returning all
+	// registered instances because too few instances are healthy
+	metricPanic = []string{"grpc_consul_resolver", "panic"}
+	// metricConnectDial counts the Connect dials by result
+	metricConnectDial = []string{"grpc_consul_resolver", "connect", "dial"}
+)
+
+// queryBackend returns the name of the query type used as the backend label,
+// i.e. *catalog.ServiceQuery is reported as service
+func queryBackend(q catalog.Query) string {
+	t := reflect.TypeOf(q)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return strings.ToLower(strings.TrimSuffix(t.Name(), "Query"))
+}
+
+// emitQueryMetrics records the latency and result of a query execution
+func (c *ConsulWatcher) emitQueryMetrics(start time.Time, err error) {
+	labels := []metrics.Label{
+		{Name: "target", Value: c.service},
+		{Name: "backend", Value: queryBackend(c.query)},
+	}
+
+	metrics.MeasureSinceWithLabels(metricQuery, start, labels)
+
+	if err != nil {
+		metrics.IncrCounterWithLabels(metricQueryError, 1, labels)
+		return
+	}
+
+	if pr, ok := c.query.(catalog.PanicReporter); ok {
+		panicking := float32(0)
+		if pr.InPanic(c.service) {
+			panicking = 1
+		}
+
+		metrics.SetGaugeWithLabels(metricPanic, panicking, labels)
+	}
+
+	// staleness is only known for queries which record the Consul QueryMeta
+	mr, ok := c.query.(catalog.MetaReporter)
+	if !ok {
+		return
+	}
+
+	if meta := mr.LastMeta(c.service); meta != nil {
+		staleness := meta.LastContact + meta.CacheAge
+		metrics.SetGaugeWithLabels(metricStaleness, float32(staleness/time.Millisecond), labels)
+	}
+}
+
+// emitUpdateMetrics records the number of updates and the endpoint count
+// after an update has been built, the caller must hold the cache mutex
+func (c *ConsulWatcher) emitUpdateMetrics(adds, deletes, drains int) {
+	labels := []metrics.Label{{Name: "target", Value: c.service}}
+
+	metrics.SetGaugeWithLabels(metricEndpoints, float32(len(c.addressCache)), labels)
+
+	if adds > 0 {
+		metrics.IncrCounterWithLabels(metricUpdate, float32(adds), append(labels, metrics.Label{Name: "op", Value: "add"}))
+	}
+
+	if deletes > 0 {
+		metrics.IncrCounterWithLabels(metricUpdate, float32(deletes), append(labels, metrics.Label{Name: "op", Value: "delete"}))
+	}
+
+	if drains > 0 {
+		metrics.IncrCounterWithLabels(metricUpdate, float32(drains), append(labels, metrics.Label{Name: "op", Value: "drain"}))
+	}
+}
+
+// emitThrottleMetrics records the time a query waited for the rate limiter,
+// queries which did not wait are not recorded
+func (c *ConsulWatcher) emitThrottleMetrics(initial bool, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+
+	priority := "refresh"
+	if initial {
+		priority = "initial"
+	}
+
+	labels := []metrics.Label{
+		{Name: "target", Value: c.service},
+		{Name: "priority", Value: priority},
+	}
+
+	metrics.IncrCounterWithLabels(metricThrottled, 1, labels)
+	metrics.AddSampleWithLabels(metricThrottleWait, float32(wait/time.Millisecond), labels)
+}
+
+// emitDialMetrics records the result of a Connect dial, mode is direct when
+// dialing the service proxy or mesh_gateway when dialing through a gateway
+func emitDialMetrics(mode string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	metrics.IncrCounterWithLabels(metricConnectDial, 1, []metrics.Label{
+		{Name: "mode", Value: mode},
+		{Name: "result", Value: result},
+	})
+}
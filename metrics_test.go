@@ -0,0 +1,88 @@
+package resolver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupMetrics(t *testing.T) *metrics.InmemSink {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+
+	conf := metrics.DefaultConfig("")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+
+	_, err := metrics.NewGlobal(conf, sink)
+	assert.NoError(t, err)
+
+	return sink
+}
+
+func TestQueryBackendReturnsQueryName(t *testing.T) {
+	assert.Equal(t, "service", queryBackend(&catalog.ServiceQuery{}))
+	assert.Equal(t, "prepared", queryBackend(&catalog.PreparedQuery{}))
+	assert.Equal(t, "mock", queryBackend(&catalog.MockQuery{}))
+}
+
+func TestNextEmitsQueryErrorMetric(t *testing.T) {
+	sink := setupMetrics(t)
+	w := setupWatcher(t)
+	queryMock.ExpectedCalls = make([]*mock.Call, 0)
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("boom"))
+
+	w.Next()
+
+	data := sink.Data()
+	assert.Equal(t, 1, data[0].Counters["grpc_consul_resolver.query.error;target=test;backend=mock"].Count)
+	assert.Equal(t, 1, data[0].Samples["grpc_consul_resolver.query;target=test;backend=mock"].Count)
+}
+
+func TestNextEmitsUpdateMetrics(t *testing.T) {
+	sink := setupMetrics(t)
+	w := setupWatcher(t)
+	ses = append(ses, catalog.ServiceEntry{Addr: "localhost:8081"})
+
+	w.Next()
+
+	data := sink.Data()
+	assert.Equal(t, float32(2), data[0].Gauges["grpc_consul_resolver.endpoints;target=test"].Value)
+	assert.Equal(t, float64(2), data[0].Counters["grpc_consul_resolver.update;target=test;op=add"].Sum)
+}
+
+func TestNextEmitsThrottleMetrics(t *testing.T) {
+	sink := setupMetrics(t)
+	w := setupWatcher(t)
+	w.limiter = setupLimiter(1, 1)
+	w.limiter.wait(false, nil)
+
+	w.Next()
+
+	data := sink.Data()
+	assert.Equal(t, 1, data[0].Counters["grpc_consul_resolver.query.throttled;target=test;priority=initial"].Count)
+	assert.Equal(t, float64(1000), data[0].Samples["grpc_consul_resolver.query.throttle_wait;target=test;priority=initial"].Sum)
+}
+
+type panicQuery struct {
+	*catalog.MockQuery
+}
+
+func (p *panicQuery) InPanic(name string) bool {
+	return true
+}
+
+func TestNextEmitsPanicMetric(t *testing.T) {
+	sink := setupMetrics(t)
+	setupWatcher(t)
+	w := NewConsulWatcher("test", &panicQuery{queryMock}, 10*time.Millisecond)
+
+	w.Next()
+
+	data := sink.Data()
+	assert.Equal(t, float32(1), data[0].Gauges["grpc_consul_resolver.panic;target=test;backend=panic"].Value)
+}
@@ -0,0 +1,250 @@
+package resolver
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/connect"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"google.golang.org/grpc"
+)
+
+// Backend is the type of Consul query used to resolve targets
+type Backend string
+
+const (
+	// ServiceBackend resolves targets using the Consul service catalog
+	ServiceBackend Backend = "service"
+	// PreparedQueryBackend resolves targets by executing the prepared query
+	// with the name or ID of the target
+	PreparedQueryBackend Backend = "prepared"
+	// ConnectBackend resolves targets using the Consul Connect service
+	// catalog, connections are secured with Connect mTLS
+	ConnectBackend Backend = "connect"
+	// SidecarBackend resolves targets to the local listener of the upstream
+	// configured on the Connect sidecar proxy of the local service
+	SidecarBackend Backend = "sidecar"
+)
+
+// Option configures the resolver returned by New
+type Option func(*options) error
+
+type options struct {
+	client         *api.Client
+	config         *api.Config
+	backend        Backend
+	connectService string
+	pollInterval   time.Duration
+	logger         catalog.Logger
+	tls            *ConsulTLSConfig
+}
+
+// WithClient uses an existing Consul API client, this can not be combined
+// with WithConfig or WithAddress
+func WithClient(c *api.Client) Option {
+	return func(o *options) error {
+		if c == nil {
+			return fmt.Errorf("Consul client must not be nil")
+		}
+
+		o.client = c
+		return nil
+	}
+}
+
+// WithConfig creates the Consul API client from the config, this allows the
+// scheme, TLS, token, datacenter and HTTP client to be set
+func WithConfig(c *api.Config) Option {
+	return func(o *options) error {
+		if c == nil {
+			return fmt.Errorf("Consul config must not be nil")
+		}
+
+		o.config = c
+		return nil
+	}
+}
+
+// WithAddress sets the address of the Consul HTTP API on the default config
+// or on a copy of the config passed to WithConfig
+func WithAddress(addr string) Option {
+	return func(o *options) error {
+		c := api.DefaultConfig()
+		if o.config != nil {
+			conf := *o.config
+			c = &conf
+		}
+
+		c.Address = addr
+		o.config = c
+		return nil
+	}
+}
+
+// WithTLS connects to the Consul HTTP API using HTTPS with the given CA,
+// client certificate and server name, this can not be combined with
+// WithClient or a config with TLSConfig or HttpClient set
+func WithTLS(c ConsulTLSConfig) Option {
+	return func(o *options) error {
+		o.tls = &c
+		return nil
+	}
+}
+
+// WithBackend sets the type of query used to resolve targets, defaults to
+// ServiceBackend
+func WithBackend(b Backend) Option {
+	return func(o *options) error {
+		switch b {
+		case ServiceBackend, PreparedQueryBackend, ConnectBackend, SidecarBackend:
+			o.backend = b
+			return nil
+		}
+
+		return fmt.Errorf("Unknown backend %s", b)
+	}
+}
+
+// WithConnectService sets the name of the local service, this is used to
+// request the Connect client certificate for ConnectBackend and to find the
+// sidecar proxy for SidecarBackend and is required for both
+func WithConnectService(name string) Option {
+	return func(o *options) error {
+		o.connectService = name
+		return nil
+	}
+}
+
+// WithPollInterval sets the interval between queries for a target
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return fmt.Errorf("Poll interval must be greater than 0")
+		}
+
+		o.pollInterval = d
+		return nil
+	}
+}
+
+// WithLogger sets the Logger for the resolver and the query
+func WithLogger(l catalog.Logger) Option {
+	return func(o *options) error {
+		o.logger = l
+		return nil
+	}
+}
+
+// New creates a ConsulResolver configured with the given options, an error
+// is returned when the configuration is not valid. The returned dial options
+// must be passed to grpc.Dial, for ConnectBackend they contain the Connect
+// dialer.
+// example usage:
+// conf := &api.Config{Address: "consul:8501", Scheme: "https", Token: token}
+// r, opts, err := resolver.New(resolver.WithConfig(conf), resolver.WithBackend(resolver.ConnectBackend), resolver.WithConnectService("my_service"))
+//
+// opts = append(opts, grpc.WithInsecure(), grpc.WithBalancer(grpc.RoundRobin(r)))
+// c, err := grpc.Dial("test_grpc", opts...)
+func New(opts ...Option) (*ConsulResolver, []grpc.DialOption, error) {
+	o := &options{backend: ServiceBackend}
+
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	client, err := o.consulClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var q catalog.Query
+	switch o.backend {
+	case ServiceBackend, ConnectBackend:
+		sq := catalog.NewServiceQuery(client, o.backend == ConnectBackend)
+		sq.Logger = o.logger
+		q = sq
+	case PreparedQueryBackend:
+		q = catalog.NewPreparedQuery(client.PreparedQuery())
+	case SidecarBackend:
+		q = catalog.NewSidecarQuery(client, o.connectService)
+	}
+
+	r := NewResolver(q)
+	if o.pollInterval > 0 {
+		r.PollInterval = o.pollInterval
+	}
+
+	if o.logger != nil {
+		r.Logger = o.logger
+	}
+
+	if o.backend != ConnectBackend {
+		return r, []grpc.DialOption{}, nil
+	}
+
+	connectService, err := connect.NewService(o.connectService, client)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to create connect service %s", err)
+	}
+
+	return r, []grpc.DialOption{r.ConnectDialer(connectService)}, nil
+}
+
+// consulClient returns the client from the options or creates a new client
+// from the config
+func (o *options) consulClient() (*api.Client, error) {
+	if o.client != nil && o.config != nil {
+		return nil, fmt.Errorf("WithClient can not be combined with WithConfig or WithAddress")
+	}
+
+	if o.client != nil && o.tls != nil {
+		return nil, fmt.Errorf("WithClient can not be combined with WithTLS")
+	}
+
+	if (o.backend == ConnectBackend || o.backend == SidecarBackend) && o.connectService == "" {
+		return nil, fmt.Errorf("WithConnectService must be set for the %s backend", o.backend)
+	}
+
+	if o.client != nil {
+		return o.client, nil
+	}
+
+	conf := o.config
+	if conf == nil {
+		conf = api.DefaultConfig()
+	}
+
+	if o.tls != nil {
+		if conf.TLSConfig != (api.TLSConfig{}) {
+			return nil, fmt.Errorf("WithTLS can not be combined with a config which sets TLSConfig")
+		}
+
+		// the transport of the client would be replaced by the TLS transport
+		if conf.HttpClient != nil {
+			return nil, fmt.Errorf("WithTLS can not be combined with a config which sets HttpClient")
+		}
+
+		t, err := newTLSTransport(*o.tls, o.logger)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to configure Consul TLS %s", err)
+		}
+
+		// copy the config so that the config passed to WithConfig is not
+		// modified
+		c := *conf
+		c.Scheme = "https"
+		c.HttpClient = &http.Client{Transport: t}
+		conf = &c
+	}
+
+	client, err := api.NewClient(conf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create Consul client %s", err)
+	}
+
+	return client, nil
+}
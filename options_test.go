@@ -0,0 +1,140 @@
+package resolver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/nicholasjackson/grpc-consul-resolver/consultest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewReturnsServiceResolverByDefault(t *testing.T) {
+	r, opts, err := New(WithAddress("localhost:8500"))
+
+	assert.NoError(t, err)
+	assert.IsType(t, &catalog.ServiceQuery{}, r.query)
+	assert.Equal(t, 60*time.Second, r.PollInterval)
+	assert.Len(t, opts, 0)
+}
+
+func TestNewReturnsPreparedQueryResolver(t *testing.T) {
+	r, _, err := New(WithBackend(PreparedQueryBackend))
+
+	assert.NoError(t, err)
+	assert.IsType(t, &catalog.PreparedQuery{}, r.query)
+}
+
+func TestNewReturnsConnectResolverAndDialer(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+
+	r, opts, err := New(WithClient(s.Client()), WithBackend(ConnectBackend), WithConnectService("my_service"))
+
+	assert.NoError(t, err)
+	assert.IsType(t, &catalog.ServiceQuery{}, r.query)
+	assert.Len(t, opts, 1)
+}
+
+func TestNewReturnsSidecarResolver(t *testing.T) {
+	r, opts, err := New(WithBackend(SidecarBackend), WithConnectService("my_service"))
+
+	assert.NoError(t, err)
+	assert.IsType(t, &catalog.SidecarQuery{}, r.query)
+	assert.Len(t, opts, 0)
+}
+
+func TestNewResolvesSidecarUpstream(t *testing.T) {
+	s := consultest.NewServer()
+	defer s.Close()
+	s.RegisterService(&api.AgentService{
+		Kind:    api.ServiceKindConnectProxy,
+		ID:      "my_service-sidecar-proxy",
+		Service: "my_service-sidecar-proxy",
+		Port:    21000,
+		Proxy: &api.AgentServiceConnectProxyConfig{
+			DestinationServiceName: "my_service",
+			Upstreams:              []api.Upstream{api.Upstream{DestinationName: "test_grpc", LocalBindPort: 9090}},
+		},
+	})
+
+	r, _, err := New(WithClient(s.Client()), WithBackend(SidecarBackend), WithConnectService("my_service"))
+	assert.NoError(t, err)
+
+	w, _ := r.Resolve("test_grpc")
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 1)
+	assert.Equal(t, "127.0.0.1:9090", nu[0].Addr)
+}
+
+func TestNewSetsPollIntervalAndLogger(t *testing.T) {
+	logger := catalog.NewMockLogger()
+
+	r, _, err := New(WithPollInterval(time.Second), WithLogger(logger))
+
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, r.PollInterval)
+	assert.Equal(t, logger, r.Logger)
+	assert.Equal(t, logger, r.query.(*catalog.ServiceQuery).Logger)
+}
+
+func assertNewReturnsError(t *testing.T, msg string, opts ...Option) {
+	r, _, err := New(opts...)
+
+	assert.Nil(t, r)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), msg)
+}
+
+func TestWithAddressDoesNotModifyConfig(t *testing.T) {
+	conf := &api.Config{Address: "localhost:8500", Token: "abc"}
+
+	o := &options{}
+	WithConfig(conf)(o)
+	WithAddress("consul:8500")(o)
+
+	assert.Equal(t, "localhost:8500", conf.Address)
+	assert.Equal(t, "consul:8500", o.config.Address)
+	assert.Equal(t, "abc", o.config.Token)
+}
+
+func TestNewReturnsErrorForNilClient(t *testing.T) {
+	assertNewReturnsError(t, "Consul client must not be nil", WithClient(nil))
+}
+
+func TestNewReturnsErrorForNilConfig(t *testing.T) {
+	assertNewReturnsError(t, "Consul config must not be nil", WithConfig(nil))
+}
+
+func TestNewReturnsErrorForUnknownBackend(t *testing.T) {
+	assertNewReturnsError(t, "Unknown backend dns", WithBackend("dns"))
+}
+
+func TestNewReturnsErrorForInvalidPollInterval(t *testing.T) {
+	assertNewReturnsError(t, "Poll interval must be greater than 0", WithPollInterval(0))
+}
+
+func TestNewReturnsErrorWhenClientAndConfig(t *testing.T) {
+	assertNewReturnsError(
+		t, "WithClient can not be combined with WithConfig or WithAddress",
+		WithClient(&api.Client{}), WithAddress("localhost:8500"),
+	)
+}
+
+func TestNewReturnsErrorWhenConnectWithoutService(t *testing.T) {
+	assertNewReturnsError(t, "WithConnectService must be set for the connect backend", WithBackend(ConnectBackend))
+}
+
+func TestNewReturnsErrorWhenSidecarWithoutService(t *testing.T) {
+	assertNewReturnsError(t, "WithConnectService must be set for the sidecar backend", WithBackend(SidecarBackend))
+}
+
+func TestNewReturnsErrorForInvalidTLSConfig(t *testing.T) {
+	assertNewReturnsError(
+		t, "Unable to create Consul client",
+		WithConfig(&api.Config{TLSConfig: api.TLSConfig{CAFile: "/does/not/exist"}}),
+	)
+}
@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a Prometheus collector which reports the current state of the
+// targets resolved by a ConsulResolver when it is scraped, the metrics
+// emitted while resolving can be exposed to Prometheus by configuring
+// go-metrics with the sink from github.com/armon/go-metrics/prometheus.
+// example usage:
+// r := resolver.NewServiceQueryResolver("http://consulAddr:8500")
+// prometheus.MustRegister(resolver.NewCollector(r))
+type Collector struct {
+	r         *ConsulResolver
+	endpoints *prometheus.Desc
+	age       *prometheus.Desc
+}
+
+// NewCollector creates a new Collector for the resolver
+func NewCollector(r *ConsulResolver) *Collector {
+	return &Collector{
+		r: r,
+		endpoints: prometheus.NewDesc(
+			"grpc_consul_resolver_target_endpoints",
+			"Number of endpoints currently resolved for the target",
+			[]string{"target"}, nil,
+		),
+		age: prometheus.NewDesc(
+			"grpc_consul_resolver_target_last_success_age_seconds",
+			"Seconds since the query for the target last succeeded",
+			[]string{"target"}, nil,
+		),
+	}
+}
+
+// Describe implements the prometheus.Collector interface
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.endpoints
+	ch <- c.age
+}
+
+// Collect implements the prometheus.Collector interface
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for target, w := range c.r.watcherList() {
+		ch <- prometheus.MustNewConstMetric(c.endpoints, prometheus.GaugeValue, float64(len(w.endpoints())), target)
+
+		// targets which have never been successfully queried are not reported
+		last := atomic.LoadInt64(&w.lastSuccess)
+		if last == 0 {
+			continue
+		}
+
+		age := time.Since(time.Unix(0, last)).Seconds()
+		ch <- prometheus.MustNewConstMetric(c.age, prometheus.GaugeValue, age, target)
+	}
+}
@@ -0,0 +1,49 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func collect(c prometheus.Collector) []prometheus.Metric {
+	ch := make(chan prometheus.Metric, 10)
+	c.Collect(ch)
+	close(ch)
+
+	ms := make([]prometheus.Metric, 0)
+	for m := range ch {
+		ms = append(ms, m)
+	}
+
+	return ms
+}
+
+func TestCollectorReportsEndpointsAndAge(t *testing.T) {
+	q := &catalog.MockQuery{}
+	q.On("Execute", "target", mock.Anything).Return(func() []catalog.ServiceEntry {
+		return []catalog.ServiceEntry{catalog.ServiceEntry{Addr: "localhost:8080"}}
+	}, nil)
+
+	r := NewResolver(q)
+	w, _ := r.Resolve("target")
+	w.Next()
+
+	ms := collect(NewCollector(r))
+
+	assert.Len(t, ms, 2)
+	assert.Contains(t, ms[0].Desc().String(), "grpc_consul_resolver_target_endpoints")
+	assert.Contains(t, ms[1].Desc().String(), "grpc_consul_resolver_target_last_success_age_seconds")
+}
+
+func TestCollectorDoesNotReportAgeBeforeFirstSuccess(t *testing.T) {
+	r := NewResolver(&catalog.MockQuery{})
+	r.Resolve("target")
+
+	ms := collect(NewCollector(r))
+
+	assert.Len(t, ms, 1)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -23,9 +25,71 @@ type ConsulResolver struct {
 	query        catalog.Query
 	PollInterval time.Duration
 	watchers     map[string]*ConsulWatcher
+	watchersMu   sync.Mutex
+
+	// subscribed contains the watchers started for subscriptions, these are
+	// independent of the watchers used by gRPC
+	subscribed   map[string]*ConsulWatcher
+	subscribedMu sync.Mutex
+
+	// Weighted enables weighted load balancing using the Consul service weights,
+	// the resolver must be used with the WeightedRoundRobin balancer
+	Weighted bool
+
+	// Tagged updates endpoints when their Consul tags change, this is set by
+	// the TagRouter balancer
+	Tagged bool
+
+	// Timestamped adds the time each endpoint was first resolved to the
+	// Metadata, this is set by the SlowStart balancer
+	Timestamped bool
+
+	// DrainPeriod keeps endpoints which have been deregistered or entered
+	// maintenance as draining for the given period instead of deleting them,
+	// it is ignored unless Weighted is set as only the WeightedRoundRobin
+	// balancer understands draining endpoints
+	DrainPeriod time.Duration
+
+	// PollJitter randomizes the interval between queries by up to the given
+	// fraction of the interval
+	PollJitter float64
+
+	// AdaptivePolling polls at MinPollInterval after the endpoints of a target
+	// change or a query fails and backs off to MaxPollInterval while the
+	// endpoints are unchanged
+	AdaptivePolling bool
+	// MinPollInterval is the interval after a change, defaults to 1 second
+	MinPollInterval time.Duration
+	// MaxPollInterval is the interval while the endpoints are unchanged,
+	// defaults to PollInterval
+	MaxPollInterval time.Duration
+	// TargetPollBounds overrides MinPollInterval and MaxPollInterval for the
+	// given targets
+	TargetPollBounds map[string]PollBounds
+
+	// QueryRate limits the total number of Consul queries per second made by
+	// the watchers of all targets, when 0 queries are not limited. Initial
+	// queries for a target take priority over refreshes.
+	// QueryRate and QueryBurst must be set before the first target is resolved
+	QueryRate float64
+	// QueryBurst is the number of queries which can be made at once before
+	// QueryRate is applied, defaults to 1
+	QueryBurst int
+	limiter    *rateLimiter
+	limiterMu  sync.Mutex
+
+	// Logger reports query failures, endpoint changes and Connect resolution
+	// misses, it is passed to the watcher for each target. Defaults to a
+	// Logger which discards all messages.
+	// The Logger is not passed to the query, the messages of the query such
+	// as the trust domain, mesh gateway and panic threshold messages are
+	// reported by the Logger of the query, i.e. ServiceQuery.Logger. New sets
+	// the Logger of both the resolver and the query.
+	Logger catalog.Logger
 }
 
 // NewServiceQueryResolver is a convenience constructor which returns a resolver for the given consul server
+// configuration errors are not returned, use New to configure the Consul client and handle errors
 func NewServiceQueryResolver(consulAddr string) *ConsulResolver {
 	conf := api.DefaultConfig()
 	conf.Address = consulAddr
@@ -40,7 +104,10 @@ func NewServiceQueryResolver(consulAddr string) *ConsulResolver {
 func NewConnectServiceQueryResolver(consulAddr, serviceName string) (*ConsulResolver, grpc.DialOption, error) {
 	conf := api.DefaultConfig()
 	conf.Address = consulAddr
-	consulClient, _ := api.NewClient(conf)
+	consulClient, err := api.NewClient(conf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to create Consul client %s", err)
+	}
 
 	connectService, err := connect.NewService(serviceName, consulClient)
 	if err != nil {
@@ -50,62 +117,393 @@ func NewConnectServiceQueryResolver(consulAddr, serviceName string) (*ConsulReso
 	sq := catalog.NewServiceQuery(consulClient, true)
 	r := NewResolver(sq)
 
+	return r, r.ConnectDialer(connectService), nil
+}
+
+// ConnectDialer returns a gRPC dial option which creates Consul Connect mTLS
+// connections to the endpoints returned by the resolver, connectService
+// provides the client certificate of the local service. The endpoint is
+// found in the watchers of every target, mesh gateways are shared by every
+// service in a datacenter so a dial to a gateway address which more than one
+// target routes through fails, use ConnectTargetDialer for these targets.
+func (g *ConsulResolver) ConnectDialer(connectService *connect.Service) grpc.DialOption {
+	return g.connectDialer(connectService, g.dialServiceEntry)
+}
+
+// ConnectTargetDialer returns a gRPC dial option for the ClientConn of the
+// given target, the endpoint is only looked up in the watcher of the target
+// so the SNI and CertURI of a mesh gateway address are those of the target.
+func (g *ConsulResolver) ConnectTargetDialer(target string, connectService *connect.Service) grpc.DialOption {
+	return g.connectDialer(connectService, g.targetLookup(target))
+}
+
+// targetLookup returns a function which finds the endpoint for an address in
+// the watcher of the target
+func (g *ConsulResolver) targetLookup(target string) func(addr string) (catalog.ServiceEntry, error) {
+	return func(addr string) (catalog.ServiceEntry, error) {
+		if w := g.targetWatcher(target); w != nil {
+			if se, ok := w.serviceEntry(addr); ok {
+				return se, nil
+			}
+		}
+
+		catalog.LoggerOrNop(g.Logger).Warn("connect resolution miss", "target", target, "address", addr)
+		return catalog.ServiceEntry{}, fmt.Errorf("Unable to resolve address")
+	}
+}
+
+// connectDialer returns the dial option using lookup to find the endpoint
+// for the address gRPC dials
+func (g *ConsulResolver) connectDialer(connectService *connect.Service, lookup func(addr string) (catalog.ServiceEntry, error)) grpc.DialOption {
 	// We need to create a custom dialer for gRPC, instead of using the built in
 	// net.Dial we will use the Dial method from the Consul Connect service.
 	// This ensures that mTLS secures the transport and the upstream service
 	// identity is valid
-	withDialer := grpc.WithDialer(func(addr string, t time.Duration) (net.Conn, error) {
-		// Dial in the Connect package requires a service resolver which returns
-		// the upstream address and the certificate info retrieved from consul
-		// when the service catalog was queried.
-		// Because service resolution has allready been carried out by the gRPC
-		// loadbalancer through the Resolver we can use the reverse lookup which
-		// takes an endpoint address as a parameter to return a connect StaticResolver
-		// containing the information required for the connection.
-		sr, err := r.StaticResolver(addr)
-		if err != nil {
-			return nil, err
+	return grpc.WithDialer(g.dialer(connectService, lookup))
+}
+
+// dialer returns the function gRPC calls to connect to an address, the
+// connection must be established within the timeout passed by gRPC
+func (g *ConsulResolver) dialer(connectService *connect.Service, lookup func(addr string) (catalog.ServiceEntry, error)) func(string, time.Duration) (net.Conn, error) {
+	return func(addr string, t time.Duration) (net.Conn, error) {
+		ctx := context.Background()
+		if t > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, t)
+			defer cancel()
 		}
 
-		return connectService.Dial(context.Background(), sr)
-	})
+		return dialConnect(ctx, connectService, addr, lookup)
+	}
+}
 
-	return r, withDialer, nil
+// DialConnectTarget creates a Consul Connect mTLS connection to an address
+// resolved for the target in the same way as the dialer returned by
+// ConnectTargetDialer, this allows connections to be made outside of gRPC.
+func (g *ConsulResolver) DialConnectTarget(ctx context.Context, target, addr string, connectService *connect.Service) (net.Conn, error) {
+	return dialConnect(ctx, connectService, addr, g.targetLookup(target))
+}
+
+// dialConnect creates the connection to the endpoint returned by lookup for
+// the address
+func dialConnect(ctx context.Context, connectService *connect.Service, addr string, lookup func(addr string) (catalog.ServiceEntry, error)) (net.Conn, error) {
+	se, err := lookup(addr)
+
+	// Services in a remote datacenter may be reached through a mesh gateway
+	// which requires the SNI of the destination service to be set
+	mode := "direct"
+	if se.SNI != "" {
+		mode = "mesh_gateway"
+	}
+
+	ctx, span := startDialSpan(ctx, addr, mode, se)
+
+	var conn net.Conn
+	if err == nil && mode == "mesh_gateway" {
+		conn, err = dialMeshGateway(ctx, connectService.ServerTLSConfig(), se)
+	} else if err == nil {
+		// Dial in the Connect package requires a service resolver which
+		// returns the upstream address and the certificate info retrieved
+		// from consul when the service catalog was queried.
+		conn, err = connectService.Dial(ctx, &connect.StaticResolver{Addr: se.Addr, CertURI: se.CertURI})
+	}
+
+	emitDialMetrics(mode, err)
+	endDialSpan(span, err)
+
+	return conn, err
+}
+
+// dialServiceEntry returns the endpoint for the address from the watchers of
+// every target, an error is returned when the address is not resolved or is
+// a gateway which routes to more than one destination
+func (g *ConsulResolver) dialServiceEntry(addr string) (catalog.ServiceEntry, error) {
+	var found *catalog.ServiceEntry
+
+	for _, w := range g.watcherList() {
+		se, ok := w.serviceEntry(addr)
+		if !ok {
+			continue
+		}
+
+		if found != nil && (found.SNI != se.SNI || certURIString(*found) != certURIString(se)) {
+			return catalog.ServiceEntry{}, fmt.Errorf("Address %s routes to more than one target, use ConnectTargetDialer", addr)
+		}
+
+		found = &se
+	}
+
+	if found == nil {
+		catalog.LoggerOrNop(g.Logger).Warn("connect resolution miss", "address", addr)
+		return catalog.ServiceEntry{}, fmt.Errorf("Unable to resolve address")
+	}
+
+	return *found, nil
+}
+
+// certURIString returns the CertURI of the endpoint as a string, an empty
+// string is returned for endpoints which are not Connect services
+func certURIString(se catalog.ServiceEntry) string {
+	if se.CertURI == nil {
+		return ""
+	}
+
+	return se.CertURI.URI().String()
 }
 
 // NewResolver returns a new ConsulResolver with the given client
 // PollInterval is set to a sensible default of 60 seconds
 func NewResolver(q catalog.Query) *ConsulResolver {
-	return &ConsulResolver{query: q, PollInterval: 60 * time.Second, watchers: make(map[string]*ConsulWatcher)}
+	return &ConsulResolver{
+		query:        q,
+		PollInterval: 60 * time.Second,
+		watchers:     make(map[string]*ConsulWatcher),
+		subscribed:   make(map[string]*ConsulWatcher),
+		Logger:       catalog.NewNopLogger(),
+	}
 }
 
 // Resolve called internally by the load balancer
 func (g *ConsulResolver) Resolve(target string) (naming.Watcher, error) {
+	w := g.newWatcher(target)
+
+	// subscriptions attached to the watcher are moved to a watcher started
+	// for the subscriptions when gRPC closes it
+	w.onClose = func() { g.handoff(target, w) }
+
+	g.watchersMu.Lock()
+	g.watchers[target] = w
+	g.watchersMu.Unlock()
+
+	return w, nil
+}
+
+// Subscribe returns a Subscription which delivers the endpoint changes for
+// the target, the endpoints already known are delivered as EventAdd.
+// Subscriptions do not require a gRPC ClientConn, when the target has been
+// resolved by gRPC the subscription receives the changes from the watcher
+// used by gRPC, otherwise a watcher is started which watches the target
+// until all of its subscriptions have been closed.
+// example usage:
+// s := r.Subscribe("test_grpc", resolver.SubscribeOptions{BufferSize: 16, Policy: resolver.DropOldest})
+// defer s.Close()
+//
+// e := <-s.Events()
+// fmt.Println(e.Type, e.Entry.Addr)
+func (g *ConsulResolver) Subscribe(target string, opts SubscribeOptions) *Subscription {
+	g.subscribedMu.Lock()
+	defer g.subscribedMu.Unlock()
+
+	s := newSubscription(opts)
+	s.cancel = func() { g.unsubscribe(target, s) }
+
+	s.watcher = g.subscriptionWatcher(target)
+	s.watcher.subscribe(s)
+
+	return s
+}
+
+// subscriptionWatcher returns the watcher used by gRPC for the target, when
+// the target has not been resolved by gRPC a watcher is started for the
+// subscriptions. The caller must hold subscribedMu
+func (g *ConsulResolver) subscriptionWatcher(target string) *ConsulWatcher {
+	if w, ok := g.watcherList()[target]; ok && atomic.LoadUint32(&w.running) == 1 {
+		return w
+	}
+
+	w, ok := g.subscribed[target]
+	if !ok {
+		w = g.newWatcher(target)
+		g.subscribed[target] = w
+
+		go watch(w)
+	}
+
+	return w
+}
+
+// unsubscribe removes the subscription from its watcher, a watcher started
+// for subscriptions is stopped when it has no remaining subscriptions
+func (g *ConsulResolver) unsubscribe(target string, s *Subscription) {
+	g.subscribedMu.Lock()
+	defer g.subscribedMu.Unlock()
+
+	w := s.watcher
+	if w == nil {
+		return
+	}
+	s.watcher = nil
+
+	if w.unsubscribe(s) > 0 || g.subscribed[target] != w {
+		return
+	}
+
+	w.Close()
+	delete(g.subscribed, target)
+}
+
+// handoff moves the subscriptions of a watcher closed by gRPC to the watcher
+// for the subscriptions, the endpoints of the closed watcher are copied so
+// that the subscriptions only receive the changes
+func (g *ConsulResolver) handoff(target string, closed *ConsulWatcher) {
+	g.subscribedMu.Lock()
+	defer g.subscribedMu.Unlock()
+
+	subs, ses := closed.takeSubscribers()
+	if len(subs) == 0 {
+		return
+	}
+
+	w, ok := g.subscribed[target]
+	if !ok {
+		w = g.newWatcher(target)
+		w.seed(ses)
+		g.subscribed[target] = w
+
+		go watch(w)
+	}
+
+	for _, s := range subs {
+		s.watcher = w
+		w.adopt(s)
+	}
+}
+
+// watch polls the watcher until it is closed, the updates for the balancer
+// are discarded as subscriptions receive their events from the watcher
+func watch(w *ConsulWatcher) {
+	for atomic.LoadUint32(&w.running) == 1 {
+		_, err := w.Next()
+		if err != nil {
+			time.Sleep(w.nextInterval())
+		}
+	}
+}
+
+// newWatcher creates a watcher for the target configured from the resolver
+func (g *ConsulResolver) newWatcher(target string) *ConsulWatcher {
 	w := NewConsulWatcher(
 		target,
 		g.query,
 		g.PollInterval,
 	)
+	w.Weighted = g.Weighted
+	w.Tagged = g.Tagged
+	w.Timestamped = g.Timestamped
+	w.DrainPeriod = g.DrainPeriod
+	w.Logger = catalog.LoggerOrNop(g.Logger)
+	w.limiter = g.rateLimiter()
+	w.Jitter = g.PollJitter
+	w.Adaptive = g.AdaptivePolling
+	w.MinInterval = g.MinPollInterval
+	w.MaxInterval = g.MaxPollInterval
 
-	g.watchers[target] = w
+	if b, ok := g.TargetPollBounds[target]; ok {
+		w.MinInterval = b.Min
+		w.MaxInterval = b.Max
+	}
 
-	return w, nil
+	return w
+}
+
+// rateLimiter returns the limiter shared by the watchers, nil is returned when
+// queries are not limited
+func (g *ConsulResolver) rateLimiter() *rateLimiter {
+	g.limiterMu.Lock()
+	defer g.limiterMu.Unlock()
+
+	if g.QueryRate <= 0 {
+		return nil
+	}
+
+	if g.limiter == nil {
+		g.limiter = newRateLimiter(g.QueryRate, g.QueryBurst)
+	}
+
+	return g.limiter
 }
 
 // StaticResolver allows fetching the service entry from the cache
 // this is a required function for the Connect static resolver which needs details from the ServiceEntry
 func (g *ConsulResolver) StaticResolver(address string) (*connect.StaticResolver, error) {
 	// find the details in the cache
-	for _, v := range g.watchers {
-		se, ok := v.addressCache[address]
+	se, ok := g.serviceEntry(address)
+	if ok {
+		return &connect.StaticResolver{
+			Addr:    se.Addr,
+			CertURI: se.CertURI,
+		}, nil
+	}
+
+	catalog.LoggerOrNop(g.Logger).Warn("connect resolution miss", "address", address)
+	return nil, fmt.Errorf("Unable to resolve address")
+}
+
+// EndpointsByTag returns the endpoints currently resolved for the target
+// grouped by their Consul tags, an endpoint with more than one tag is
+// returned in each group and endpoints without tags are not returned
+func (g *ConsulResolver) EndpointsByTag(target string) map[string][]catalog.ServiceEntry {
+	groups := make(map[string][]catalog.ServiceEntry)
+
+	w, ok := g.watcherList()[target]
+	if !ok {
+		return groups
+	}
+
+	for _, se := range w.endpoints() {
+		for _, t := range se.Tags {
+			groups[t] = append(groups[t], se)
+		}
+	}
+
+	return groups
+}
+
+// serviceEntry returns the ServiceEntry for the address from the watcher caches
+func (g *ConsulResolver) serviceEntry(address string) (catalog.ServiceEntry, bool) {
+	for _, v := range g.watcherList() {
+		se, ok := v.serviceEntry(address)
 
 		if ok {
-			return &connect.StaticResolver{
-				Addr:    se.Addr,
-				CertURI: se.CertURI,
-			}, nil
+			return se, true
 		}
 	}
 
-	return nil, fmt.Errorf("Unable to resolve address")
+	return catalog.ServiceEntry{}, false
+}
+
+// targetWatcher returns the watcher for the target started by gRPC or for
+// subscriptions, nil is returned when the target is not being watched
+func (g *ConsulResolver) targetWatcher(target string) *ConsulWatcher {
+	if w, ok := g.watcherList()[target]; ok {
+		return w
+	}
+
+	return g.subscribedList()[target]
+}
+
+// subscribedList returns a copy of the watchers started for subscriptions
+func (g *ConsulResolver) subscribedList() map[string]*ConsulWatcher {
+	g.subscribedMu.Lock()
+	defer g.subscribedMu.Unlock()
+
+	watchers := make(map[string]*ConsulWatcher, len(g.subscribed))
+	for k, v := range g.subscribed {
+		watchers[k] = v
+	}
+
+	return watchers
+}
+
+// watcherList returns a copy of the watchers for each target
+func (g *ConsulResolver) watcherList() map[string]*ConsulWatcher {
+	g.watchersMu.Lock()
+	defer g.watchersMu.Unlock()
+
+	watchers := make(map[string]*ConsulWatcher, len(g.watchers))
+	for k, v := range g.watchers {
+		watchers[k] = v
+	}
+
+	return watchers
 }
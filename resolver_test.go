@@ -24,6 +24,16 @@ func TestResolveRerturnsWatcher(t *testing.T) {
 	assert.NotNil(t, w)
 }
 
+func TestResolvePassesLoggerToWatcher(t *testing.T) {
+	r := NewResolver(&catalog.MockQuery{})
+	logger := catalog.NewMockLogger()
+	r.Logger = logger
+
+	w, _ := r.Resolve("target")
+
+	assert.Equal(t, logger, w.(*ConsulWatcher).Logger)
+}
+
 func TestStaticResolverReturnsStaticResolver(t *testing.T) {
 	r := NewResolver(&catalog.MockQuery{})
 
@@ -45,3 +55,31 @@ func TestStaticResolverReturnsStaticResolver(t *testing.T) {
 	assert.Equal(t, "localhost:8181", addr)
 	assert.Equal(t, "spiffe://abc123/ns/default/dc/dc1/svc/tester", certURI.URI().String())
 }
+
+func TestStaticResolverLogsMissWhenAddressNotFound(t *testing.T) {
+	r := NewResolver(&catalog.MockQuery{})
+	logger := catalog.NewMockLogger()
+	r.Logger = logger
+	r.Resolve("target")
+
+	_, err := r.StaticResolver("localhost:8080")
+
+	assert.Error(t, err)
+	logger.AssertCalled(t, "Warn", "connect resolution miss", []interface{}{"address", "localhost:8080"})
+}
+
+func TestEndpointsByTagGroupsEndpoints(t *testing.T) {
+	r := NewResolver(&catalog.MockQuery{})
+
+	w, _ := r.Resolve("target")
+	w.(*ConsulWatcher).addressCache["localhost:8080"] = catalog.ServiceEntry{Addr: "localhost:8080", Tags: []string{"canary", "v2"}}
+	w.(*ConsulWatcher).addressCache["localhost:8081"] = catalog.ServiceEntry{Addr: "localhost:8081", Tags: []string{"v1"}}
+	w.(*ConsulWatcher).addressCache["localhost:8082"] = catalog.ServiceEntry{Addr: "localhost:8082"}
+
+	groups := r.EndpointsByTag("target")
+
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "localhost:8080", groups["canary"][0].Addr)
+	assert.Equal(t, "localhost:8080", groups["v2"][0].Addr)
+	assert.Equal(t, "localhost:8081", groups["v1"][0].Addr)
+}
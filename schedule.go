@@ -0,0 +1,84 @@
+package resolver
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMinPollInterval is the interval after a change when adaptive polling
+// is enabled and no minimum has been set
+const defaultMinPollInterval = 1 * time.Second
+
+// PollBounds are the minimum and maximum interval between the queries for a
+// target when adaptive polling is enabled
+type PollBounds struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// resetInterval is called when the endpoints change or the query returns an
+// error, when adaptive the next query is made after the minimum interval
+func (c *ConsulWatcher) resetInterval() {
+	atomic.StoreInt64(&c.current, 0)
+}
+
+// nextInterval returns the time to wait before the next query. When adaptive
+// the interval starts at the minimum after a change and doubles each time the
+// endpoints are unchanged until the maximum is reached. Jitter is applied to
+// the interval so that watchers started together do not query in lockstep.
+func (c *ConsulWatcher) nextInterval() time.Duration {
+	d := c.update
+
+	if c.Adaptive {
+		min, max := c.bounds()
+
+		d = time.Duration(atomic.LoadInt64(&c.current)) * 2
+		if d < min {
+			d = min
+		}
+
+		if d > max {
+			d = max
+		}
+
+		atomic.StoreInt64(&c.current, int64(d))
+	}
+
+	return jitter(d, c.Jitter)
+}
+
+// bounds returns the minimum and maximum interval, the maximum defaults to
+// the watch interval
+func (c *ConsulWatcher) bounds() (time.Duration, time.Duration) {
+	min, max := c.MinInterval, c.MaxInterval
+
+	if max <= 0 {
+		max = c.update
+	}
+
+	if min <= 0 {
+		min = defaultMinPollInterval
+	}
+
+	if min > max {
+		min = max
+	}
+
+	return min, max
+}
+
+// jitter randomizes the duration by up to the given fraction in either
+// direction, i.e. a fraction of 0.1 returns a duration between 90% and 110%
+// of d
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	return d + time.Duration(float64(d)*fraction*(2*rand.Float64()-1))
+}
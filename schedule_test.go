@@ -0,0 +1,115 @@
+package resolver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNextIntervalReturnsWatchIntervalWhenNotAdaptive(t *testing.T) {
+	w := setupWatcher(t)
+
+	assert.Equal(t, 10*time.Millisecond, w.nextInterval())
+}
+
+func TestNextIntervalAppliesJitter(t *testing.T) {
+	w := setupWatcher(t)
+	w.update = time.Second
+	w.Jitter = 0.1
+
+	for i := 0; i < 100; i++ {
+		d := w.nextInterval()
+
+		assert.True(t, d >= 900*time.Millisecond && d <= 1100*time.Millisecond, "Interval %s should be within 10%% of 1s", d)
+	}
+}
+
+func TestNextIntervalBacksOffWhenAdaptive(t *testing.T) {
+	w := setupWatcher(t)
+	w.Adaptive = true
+	w.MinInterval = time.Second
+	w.MaxInterval = 5 * time.Second
+
+	assert.Equal(t, 1*time.Second, w.nextInterval())
+	assert.Equal(t, 2*time.Second, w.nextInterval())
+	assert.Equal(t, 4*time.Second, w.nextInterval())
+	assert.Equal(t, 5*time.Second, w.nextInterval())
+	assert.Equal(t, 5*time.Second, w.nextInterval())
+
+	w.resetInterval()
+	assert.Equal(t, 1*time.Second, w.nextInterval())
+}
+
+func TestNextIntervalDefaultsBoundsWhenAdaptive(t *testing.T) {
+	w := setupWatcher(t)
+	w.update = 10 * time.Second
+	w.Adaptive = true
+
+	min, max := w.bounds()
+
+	assert.Equal(t, time.Second, min)
+	assert.Equal(t, 10*time.Second, max)
+}
+
+func TestNextResetsIntervalOnChange(t *testing.T) {
+	w := setupWatcher(t)
+	w.Adaptive = true
+	w.current = int64(time.Minute)
+
+	w.Next()
+	assert.Equal(t, int64(0), w.current)
+}
+
+func TestNextRetriesAfterMinIntervalOnErrorWhenAdaptive(t *testing.T) {
+	w := setupWatcher(t)
+	w.Adaptive = true
+	w.MinInterval = 5 * time.Millisecond
+	w.MaxInterval = time.Minute
+	w.current = int64(time.Minute)
+	queryMock.ExpectedCalls = make([]*mock.Call, 0)
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Boom")).Once()
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(getServices, nil)
+
+	start := time.Now()
+	up, err := w.Next()
+
+	assert.Nil(t, err)
+	assert.Len(t, up, 1)
+	assert.True(t, time.Since(start) >= 5*time.Millisecond, "should wait for the minimum interval before retrying")
+	assert.True(t, time.Since(start) < time.Second, "should not wait for the maximum interval before retrying")
+	queryMock.AssertNumberOfCalls(t, "Execute", 2)
+}
+
+func TestNextReturnsErrorWhenNotAdaptive(t *testing.T) {
+	w := setupWatcher(t)
+	queryMock.ExpectedCalls = make([]*mock.Call, 0)
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Boom")).Once()
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(getServices, nil)
+
+	_, err := w.Next()
+
+	assert.NotNil(t, err)
+	queryMock.AssertNumberOfCalls(t, "Execute", 1)
+}
+
+func TestResolveAppliesTargetPollBounds(t *testing.T) {
+	r := NewResolver(nil)
+	r.PollJitter = 0.2
+	r.AdaptivePolling = true
+	r.MinPollInterval = time.Second
+	r.MaxPollInterval = time.Minute
+	r.TargetPollBounds = map[string]PollBounds{"slow": PollBounds{Min: 10 * time.Second, Max: 10 * time.Minute}}
+
+	fast := r.newWatcher("fast")
+	slow := r.newWatcher("slow")
+
+	assert.Equal(t, 0.2, fast.Jitter)
+	assert.True(t, fast.Adaptive)
+	assert.Equal(t, time.Second, fast.MinInterval)
+	assert.Equal(t, time.Minute, fast.MaxInterval)
+	assert.Equal(t, 10*time.Second, slow.MinInterval)
+	assert.Equal(t, 10*time.Minute, slow.MaxInterval)
+}
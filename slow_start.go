@@ -0,0 +1,92 @@
+package resolver
+
+import (
+	"math"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// SlowStartCurve returns the fraction of its weight an endpoint receives for
+// the progress through the slow start window, progress is between 0 and 1
+type SlowStartCurve func(progress float64) float64
+
+// LinearSlowStart increases the weight of an endpoint linearly over the
+// slow start window
+func LinearSlowStart(progress float64) float64 {
+	return progress
+}
+
+// AggressiveSlowStart returns a curve which increases the weight of an
+// endpoint by progress^(1/aggression), an aggression greater than 1 ramps up
+// quickly at the start of the window and less than 1 ramps up slowly. An
+// aggression of 0 or less is not valid and returns LinearSlowStart.
+func AggressiveSlowStart(aggression float64) SlowStartCurve {
+	if aggression <= 0 {
+		return LinearSlowStart
+	}
+
+	return func(progress float64) float64 {
+		return math.Pow(progress, 1/aggression)
+	}
+}
+
+// SlowStartConfig configures the slow start of new endpoints
+type SlowStartConfig struct {
+	// Window is the period over which the weight of a new endpoint is
+	// increased to its full weight
+	Window time.Duration
+	// Curve defines how the weight is increased, defaults to LinearSlowStart
+	Curve SlowStartCurve
+	// MinWeight is the minimum fraction of its weight an endpoint receives
+	// during the window, defaults to 0.1
+	MinWeight float64
+}
+
+// SlowStart returns a Balancer which selects addresses using a weighted round
+// robin where endpoints added by the resolver receive a reduced share of
+// traffic which increases to their full weight over the slow start window.
+// The window starts when the endpoint is first resolved, endpoints whose
+// weight changes do not restart the window.
+// SlowStart sets Weighted and Timestamped on the resolver so that the weights
+// and the time each endpoint was added are sent to the balancer, the resolver
+// should only be used by balancers which understand these updates.
+// example usage:
+// r := resolver.NewServiceQueryResolver("http://consulAddr:8500")
+// lb := resolver.SlowStart(r, resolver.SlowStartConfig{Window: 60 * time.Second})
+func SlowStart(r *ConsulResolver, c SlowStartConfig) grpc.Balancer {
+	r.Weighted = true
+	r.Timestamped = true
+
+	if c.Curve == nil {
+		c.Curve = LinearSlowStart
+	}
+
+	if c.MinWeight <= 0 {
+		c.MinWeight = 0.1
+	}
+
+	return &weightedRoundRobin{r: r, slowStart: &c, now: time.Now}
+}
+
+// factor returns the fraction of its weight an endpoint which was added
+// elapsed ago receives
+func (c *SlowStartConfig) factor(elapsed time.Duration) float64 {
+	if c.Window <= 0 || elapsed >= c.Window {
+		return 1
+	}
+
+	f := c.Curve(float64(elapsed) / float64(c.Window))
+
+	return math.Max(c.MinWeight, math.Min(f, 1))
+}
+
+// effectiveWeight returns the weight of the address adjusted for slow start
+func (wr *weightedRoundRobin) effectiveWeight(a *weightedAddr) float64 {
+	w := float64(a.weight)
+	if wr.slowStart == nil || a.added.IsZero() {
+		return w
+	}
+
+	return w * wr.slowStart.factor(wr.now().Sub(a.added))
+}
@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/naming"
+)
+
+func TestSlowStartFactorIncreasesLinearly(t *testing.T) {
+	c := SlowStartConfig{Window: 10 * time.Second, Curve: LinearSlowStart, MinWeight: 0.1}
+
+	assert.Equal(t, 0.1, c.factor(0))
+	assert.Equal(t, 0.5, c.factor(5*time.Second))
+	assert.Equal(t, 1.0, c.factor(10*time.Second))
+	assert.Equal(t, 1.0, c.factor(time.Minute))
+}
+
+func TestAggressiveSlowStartAppliesCurve(t *testing.T) {
+	c := SlowStartConfig{Window: 10 * time.Second, Curve: AggressiveSlowStart(2), MinWeight: 0.1}
+
+	assert.InDelta(t, 0.5, c.factor(2500*time.Millisecond), 0.0001)
+}
+
+func TestAggressiveSlowStartIsLinearWhenAggressionNotPositive(t *testing.T) {
+	assert.Equal(t, 0.5, AggressiveSlowStart(0)(0.5))
+	assert.Equal(t, 0.5, AggressiveSlowStart(-2)(0.5))
+}
+
+func TestSlowStartSetsResolverDefaults(t *testing.T) {
+	r := NewServiceQueryResolver("localhost:8500")
+
+	b := SlowStart(r, SlowStartConfig{Window: time.Minute}).(*weightedRoundRobin)
+
+	assert.True(t, r.Weighted)
+	assert.True(t, r.Timestamped)
+	assert.NotNil(t, b.slowStart.Curve)
+	assert.Equal(t, 0.1, b.slowStart.MinWeight)
+}
+
+func TestSlowStartReducesShareOfNewAddresses(t *testing.T) {
+	now := time.Now()
+
+	w := &testWatcher{make(chan []*naming.Update, 1)}
+	w.updates <- []*naming.Update{
+		&naming.Update{Op: naming.Add, Addr: "localhost:8080", Metadata: Metadata{Weight: 1, Added: now.Add(-time.Hour)}},
+		&naming.Update{Op: naming.Add, Addr: "localhost:8081", Metadata: Metadata{Weight: 1, Added: now.Add(-2500 * time.Millisecond)}},
+	}
+
+	b := &weightedRoundRobin{
+		r:         &testResolver{w},
+		slowStart: &SlowStartConfig{Window: 10 * time.Second, Curve: LinearSlowStart, MinWeight: 0.1},
+		now:       func() time.Time { return now },
+	}
+	b.Start("test", grpc.BalancerConfig{})
+	defer b.Close()
+
+	for _, a := range <-b.Notify() {
+		b.Up(a)
+	}
+
+	calls := map[string]int{}
+	for i := 0; i < 10; i++ {
+		a, _, err := b.Get(context.Background(), grpc.BalancerGetOptions{})
+		assert.NoError(t, err)
+		calls[a.Addr]++
+	}
+
+	assert.Equal(t, 8, calls["localhost:8080"])
+	assert.Equal(t, 2, calls["localhost:8081"])
+}
+
+func TestNextKeepsAddedTimeWhenWeightChanges(t *testing.T) {
+	w := setupWatcher(t)
+	w.Weighted = true
+	w.Timestamped = true
+	ses[0].Weight = 5
+
+	nu, _ := w.Next()
+	added := nu[0].Metadata.(Metadata).Added
+	assert.False(t, added.IsZero())
+
+	ses[0].Weight = 1
+	nu, _ = w.Next()
+
+	assert.Len(t, nu, 2, "Should have returned 2 updates")
+	assert.Equal(t, Metadata{Weight: 1, Added: added}, nu[1].Metadata)
+}
@@ -0,0 +1,180 @@
+package resolver
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+)
+
+// EventType is the type of change to an endpoint
+type EventType int
+
+const (
+	// EventAdd is sent when an endpoint is added to the target
+	EventAdd EventType = iota
+	// EventRemove is sent when an endpoint is removed from the target
+	EventRemove
+	// EventModify is sent when the ServiceEntry of an existing endpoint changes
+	EventModify
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventAdd:
+		return "add"
+	case EventRemove:
+		return "remove"
+	case EventModify:
+		return "modify"
+	}
+
+	return "unknown"
+}
+
+// Event is a change to an endpoint of a subscribed target
+type Event struct {
+	Type   EventType
+	Target string
+	// Entry is the current ServiceEntry, for EventRemove this is the last
+	// known ServiceEntry of the endpoint
+	Entry catalog.ServiceEntry
+	// Previous is the ServiceEntry before the change, only set for EventModify
+	Previous catalog.ServiceEntry
+}
+
+// SlowConsumerPolicy defines what happens when the buffer of a Subscription
+// is full, events are never allowed to block the watcher
+type SlowConsumerPolicy int
+
+const (
+	// DropNewest discards the event which does not fit in the buffer
+	DropNewest SlowConsumerPolicy = iota
+	// DropOldest discards the oldest buffered event to make room for the new
+	// event
+	DropOldest
+	// Disconnect closes the Subscription, the consumer should subscribe again
+	// to receive the current endpoints
+	Disconnect
+)
+
+// SubscribeOptions configures a Subscription
+type SubscribeOptions struct {
+	// BufferSize is the number of events buffered for the consumer, defaults
+	// to 64
+	BufferSize int
+	// Policy is applied when the buffer is full, defaults to DropNewest
+	Policy SlowConsumerPolicy
+}
+
+// Subscription delivers the endpoint changes for a target
+type Subscription struct {
+	events  chan Event
+	policy  SlowConsumerPolicy
+	dropped uint64
+
+	mutex  sync.Mutex
+	closed bool
+	cancel func()
+
+	// watcher delivers the events, it is guarded by the subscribedMu of the
+	// resolver
+	watcher *ConsulWatcher
+}
+
+func newSubscription(opts SubscribeOptions) *Subscription {
+	size := opts.BufferSize
+	if size <= 0 {
+		size = 64
+	}
+
+	return &Subscription{
+		events: make(chan Event, size),
+		policy: opts.Policy,
+	}
+}
+
+// Events returns the channel the events are delivered on, the channel is
+// closed when the Subscription is closed
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns the number of events which have been discarded because the
+// buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the Subscription and closes the events channel
+func (s *Subscription) Close() {
+	if !s.close() {
+		return
+	}
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// close marks the subscription closed and returns false when it had already
+// been closed
+func (s *Subscription) close() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.closed = true
+	close(s.events)
+
+	return true
+}
+
+// publish sends the event without blocking, when the buffer is full the
+// slow consumer policy is applied
+func (s *Subscription) publish(e Event) {
+	s.mutex.Lock()
+
+	if s.closed {
+		s.mutex.Unlock()
+		return
+	}
+
+	select {
+	case s.events <- e:
+		s.mutex.Unlock()
+		return
+	default:
+	}
+
+	switch s.policy {
+	case DropOldest:
+		select {
+		case <-s.events:
+		default:
+		}
+
+		select {
+		case s.events <- e:
+		default:
+		}
+
+		atomic.AddUint64(&s.dropped, 1)
+		s.mutex.Unlock()
+	case Disconnect:
+		atomic.AddUint64(&s.dropped, 1)
+		s.mutex.Unlock()
+
+		// the watcher calls publish while holding its cache lock, unsubscribe
+		// from a new goroutine to avoid a deadlock
+		if s.close() && s.cancel != nil {
+			go s.cancel()
+		}
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		s.mutex.Unlock()
+	}
+}
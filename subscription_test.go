@@ -0,0 +1,226 @@
+package resolver
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var subscribedServices []catalog.ServiceEntry
+var subscribedMutex sync.Mutex
+
+// setSubscribedServices replaces the services returned by the query, the
+// query is executed concurrently by the subscription watcher
+func setSubscribedServices(s ...catalog.ServiceEntry) {
+	subscribedMutex.Lock()
+	defer subscribedMutex.Unlock()
+
+	subscribedServices = s
+}
+
+func setupSubscription(t *testing.T) *ConsulResolver {
+	setSubscribedServices(catalog.ServiceEntry{Addr: "localhost:8080", Weight: 1})
+
+	queryMock = &catalog.MockQuery{}
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(func() []catalog.ServiceEntry {
+		subscribedMutex.Lock()
+		defer subscribedMutex.Unlock()
+
+		return subscribedServices
+	}, nil)
+
+	r := NewResolver(queryMock)
+	r.PollInterval = 10 * time.Millisecond
+
+	return r
+}
+
+func nextEvent(t *testing.T, s *Subscription) Event {
+	select {
+	case e := <-s.Events():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+
+	return Event{}
+}
+
+func TestSubscribeDeliversAddEvents(t *testing.T) {
+	r := setupSubscription(t)
+
+	s := r.Subscribe("test", SubscribeOptions{})
+	defer s.Close()
+
+	e := nextEvent(t, s)
+
+	assert.Equal(t, EventAdd, e.Type)
+	assert.Equal(t, "test", e.Target)
+	assert.Equal(t, "localhost:8080", e.Entry.Addr)
+}
+
+func TestSubscribeDeliversModifyAndRemoveEvents(t *testing.T) {
+	r := setupSubscription(t)
+
+	s := r.Subscribe("test", SubscribeOptions{})
+	defer s.Close()
+	nextEvent(t, s)
+
+	setSubscribedServices(catalog.ServiceEntry{Addr: "localhost:8080", Weight: 5})
+
+	e := nextEvent(t, s)
+	assert.Equal(t, EventModify, e.Type)
+	assert.Equal(t, 5, e.Entry.Weight)
+	assert.Equal(t, 1, e.Previous.Weight)
+
+	setSubscribedServices()
+
+	e = nextEvent(t, s)
+	assert.Equal(t, EventRemove, e.Type)
+	assert.Equal(t, "localhost:8080", e.Entry.Addr)
+}
+
+func TestSubscribeSendsCurrentEndpointsToNewSubscriptions(t *testing.T) {
+	r := setupSubscription(t)
+
+	s1 := r.Subscribe("test", SubscribeOptions{})
+	defer s1.Close()
+	nextEvent(t, s1)
+
+	s2 := r.Subscribe("test", SubscribeOptions{})
+	defer s2.Close()
+	e := nextEvent(t, s2)
+
+	assert.Equal(t, EventAdd, e.Type)
+	assert.Equal(t, "localhost:8080", e.Entry.Addr)
+	assert.Len(t, r.subscribed, 1)
+}
+
+func TestCloseStopsWatcherWhenNoSubscriptions(t *testing.T) {
+	r := setupSubscription(t)
+
+	s1 := r.Subscribe("test", SubscribeOptions{})
+	s2 := r.Subscribe("test", SubscribeOptions{})
+	w := r.subscribed["test"]
+
+	s1.Close()
+	assert.Len(t, r.subscribed, 1)
+
+	s2.Close()
+	s2.Close()
+	assert.Len(t, r.subscribed, 0)
+	assert.Equal(t, uint32(0), w.running)
+
+	_, ok := <-s2.Events()
+	assert.False(t, ok, "Events should be closed")
+}
+
+func TestSubscriptionsAreNotReturnedAsWatchers(t *testing.T) {
+	r := setupSubscription(t)
+
+	s := r.Subscribe("test", SubscribeOptions{})
+	defer s.Close()
+
+	assert.Len(t, r.watcherList(), 0)
+}
+
+func TestSubscribeUsesWatcherOfResolvedTarget(t *testing.T) {
+	r := setupSubscription(t)
+	w, _ := r.Resolve("test")
+	defer w.Close()
+
+	s := r.Subscribe("test", SubscribeOptions{})
+	defer s.Close()
+
+	w.Next()
+	e := nextEvent(t, s)
+
+	assert.Equal(t, EventAdd, e.Type)
+	assert.Len(t, r.subscribedList(), 0)
+	queryMock.AssertNumberOfCalls(t, "Execute", 1)
+}
+
+func TestSubscribeStartsWatcherWhenResolvedTargetClosed(t *testing.T) {
+	r := setupSubscription(t)
+	w, _ := r.Resolve("test")
+	w.Close()
+
+	s := r.Subscribe("test", SubscribeOptions{})
+	defer s.Close()
+
+	assert.Len(t, r.subscribedList(), 1)
+}
+
+func TestSubscriptionsMoveToNewWatcherWhenResolvedTargetCloses(t *testing.T) {
+	r := setupSubscription(t)
+	w, _ := r.Resolve("test")
+	w.Next()
+
+	s := r.Subscribe("test", SubscribeOptions{})
+	nextEvent(t, s)
+
+	w.Close()
+	assert.Len(t, r.subscribedList(), 1)
+
+	setSubscribedServices()
+
+	// the endpoints of the closed watcher are not sent again
+	e := nextEvent(t, s)
+	assert.Equal(t, EventRemove, e.Type)
+	assert.Equal(t, "localhost:8080", e.Entry.Addr)
+
+	s.Close()
+	assert.Len(t, r.subscribedList(), 0)
+}
+
+func TestPublishDropNewestDiscardsNewEvent(t *testing.T) {
+	s := newSubscription(SubscribeOptions{BufferSize: 1, Policy: DropNewest})
+
+	s.publish(Event{Entry: catalog.ServiceEntry{Addr: "a"}})
+	s.publish(Event{Entry: catalog.ServiceEntry{Addr: "b"}})
+
+	assert.Equal(t, uint64(1), s.Dropped())
+	assert.Equal(t, "a", (<-s.Events()).Entry.Addr)
+}
+
+func TestPublishDropOldestDiscardsBufferedEvent(t *testing.T) {
+	s := newSubscription(SubscribeOptions{BufferSize: 1, Policy: DropOldest})
+
+	s.publish(Event{Entry: catalog.ServiceEntry{Addr: "a"}})
+	s.publish(Event{Entry: catalog.ServiceEntry{Addr: "b"}})
+
+	assert.Equal(t, uint64(1), s.Dropped())
+	assert.Equal(t, "b", (<-s.Events()).Entry.Addr)
+}
+
+func TestPublishDisconnectClosesSubscription(t *testing.T) {
+	s := newSubscription(SubscribeOptions{BufferSize: 1, Policy: Disconnect})
+	cancelled := make(chan struct{})
+	s.cancel = func() { close(cancelled) }
+
+	s.publish(Event{Entry: catalog.ServiceEntry{Addr: "a"}})
+	s.publish(Event{Entry: catalog.ServiceEntry{Addr: "b"}})
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for subscription to be cancelled")
+	}
+
+	assert.Equal(t, "a", (<-s.Events()).Entry.Addr)
+	_, ok := <-s.Events()
+	assert.False(t, ok, "Events should be closed")
+
+	// publishing to a closed subscription is ignored
+	s.publish(Event{Entry: catalog.ServiceEntry{Addr: "c"}})
+}
+
+func TestEventTypeString(t *testing.T) {
+	assert.Equal(t, "add", EventAdd.String())
+	assert.Equal(t, "remove", EventRemove.String())
+	assert.Equal(t, "modify", EventModify.String())
+}
@@ -0,0 +1,95 @@
+package resolver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/naming"
+)
+
+// TagRoute routes requests where the outgoing gRPC metadata contains Key
+// with the value Value to the endpoints with the Consul tag Tag
+type TagRoute struct {
+	Key   string
+	Value string
+	Tag   string
+}
+
+// TagRouter returns a Balancer which routes requests to groups of endpoints
+// based on the outgoing metadata of the request and the Consul tags of the
+// endpoints. Requests matching a route are sent to the endpoints with the
+// tag of the route, all other requests are sent to the endpoints which do not
+// have the tag of any route. When the group for a request has no connected
+// endpoints the request falls back to the endpoints without a route tag and
+// then to all endpoints.
+// Within each group endpoints are selected using a weighted round robin of
+// their Consul service weights.
+// TagRouter sets Tagged and Weighted on the resolver so that the tags and
+// weights of the endpoints are sent to the balancer, the resolver should
+// only be used by balancers which understand these updates.
+// example usage:
+// r := resolver.NewServiceQueryResolver("http://consulAddr:8500")
+// lb := resolver.TagRouter(r, resolver.TagRoute{Key: "x-canary", Value: "true", Tag: "canary"})
+//
+// ctx := metadata.AppendToOutgoingContext(ctx, "x-canary", "true")
+func TagRouter(r *ConsulResolver, routes ...TagRoute) grpc.Balancer {
+	r.Tagged = true
+	r.Weighted = true
+
+	return &weightedRoundRobin{
+		r:      r,
+		routes: routes,
+		tags: func(w naming.Watcher, addr string) []string {
+			// the address is looked up in the watcher for the target of the
+			// balancer as other targets may share the address
+			cw, ok := w.(*ConsulWatcher)
+			if !ok {
+				return nil
+			}
+
+			se, _ := cw.serviceEntry(addr)
+			return se.Tags
+		},
+	}
+}
+
+// routeTag returns the tag of the first route matching the outgoing metadata
+// of the request, an empty string is returned when no route matches
+func (wr *weightedRoundRobin) routeTag(ctx context.Context) string {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	for _, r := range wr.routes {
+		for _, v := range md.Get(r.Key) {
+			if v == r.Value {
+				return r.Tag
+			}
+		}
+	}
+
+	return ""
+}
+
+// isDefault returns true when the address does not have the tag of any route
+func (wr *weightedRoundRobin) isDefault(a *weightedAddr) bool {
+	for _, r := range wr.routes {
+		if hasTag(a.tags, r.Tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,133 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func setupTagRouter(t *testing.T, entries []catalog.ServiceEntry) grpc.Balancer {
+	q := &catalog.MockQuery{}
+	q.On("Execute", "test", mock.Anything).Return(func() []catalog.ServiceEntry { return entries }, nil)
+
+	r := NewResolver(q)
+	r.PollInterval = 10 * time.Millisecond
+
+	b := TagRouter(r, TagRoute{Key: "x-canary", Value: "true", Tag: "canary"})
+	err := b.Start("test", grpc.BalancerConfig{})
+	assert.NoError(t, err)
+
+	for _, a := range <-b.Notify() {
+		b.Up(a)
+	}
+
+	return b
+}
+
+func canaryContext() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-canary", "true")
+}
+
+func TestTagRouterRoutesMatchingRequestsToTaggedEndpoints(t *testing.T) {
+	b := setupTagRouter(t, []catalog.ServiceEntry{
+		catalog.ServiceEntry{Addr: "localhost:8080", Weight: 1},
+		catalog.ServiceEntry{Addr: "localhost:8081", Weight: 1, Tags: []string{"canary"}},
+	})
+	defer b.Close()
+
+	for i := 0; i < 4; i++ {
+		a, _, err := b.Get(canaryContext(), grpc.BalancerGetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "localhost:8081", a.Addr)
+	}
+}
+
+func TestTagRouterRoutesOtherRequestsToUntaggedEndpoints(t *testing.T) {
+	b := setupTagRouter(t, []catalog.ServiceEntry{
+		catalog.ServiceEntry{Addr: "localhost:8080", Weight: 1},
+		catalog.ServiceEntry{Addr: "localhost:8081", Weight: 1, Tags: []string{"canary"}},
+	})
+	defer b.Close()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-canary", "false")
+
+	for i := 0; i < 4; i++ {
+		a, _, err := b.Get(ctx, grpc.BalancerGetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "localhost:8080", a.Addr)
+	}
+}
+
+func TestTagRouterFallsBackWhenGroupIsEmpty(t *testing.T) {
+	b := setupTagRouter(t, []catalog.ServiceEntry{
+		catalog.ServiceEntry{Addr: "localhost:8080", Weight: 1},
+	})
+	defer b.Close()
+
+	a, _, err := b.Get(canaryContext(), grpc.BalancerGetOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:8080", a.Addr)
+}
+
+func TestTagRouterFallsBackToAllEndpointsWhenNoUntaggedEndpoints(t *testing.T) {
+	b := setupTagRouter(t, []catalog.ServiceEntry{
+		catalog.ServiceEntry{Addr: "localhost:8081", Weight: 1, Tags: []string{"canary"}},
+	})
+	defer b.Close()
+
+	a, _, err := b.Get(context.Background(), grpc.BalancerGetOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:8081", a.Addr)
+}
+
+func TestTagRouterUsesTagsOfItsOwnTarget(t *testing.T) {
+	q := &catalog.MockQuery{}
+	q.On("Execute", "test", mock.Anything).Return(func() []catalog.ServiceEntry {
+		return []catalog.ServiceEntry{
+			catalog.ServiceEntry{Addr: "localhost:8080", Weight: 1},
+			catalog.ServiceEntry{Addr: "localhost:8081", Weight: 1, Tags: []string{"canary"}},
+		}
+	}, nil)
+	q.On("Execute", "other", mock.Anything).Return(func() []catalog.ServiceEntry {
+		return []catalog.ServiceEntry{catalog.ServiceEntry{Addr: "localhost:8080", Weight: 1, Tags: []string{"canary"}}}
+	}, nil)
+
+	r := NewResolver(q)
+	r.PollInterval = 10 * time.Millisecond
+
+	// another target which shares an address with different tags
+	w, _ := r.Resolve("other")
+	w.Next()
+	defer w.Close()
+
+	b := TagRouter(r, TagRoute{Key: "x-canary", Value: "true", Tag: "canary"})
+	b.Start("test", grpc.BalancerConfig{})
+	defer b.Close()
+
+	for _, a := range <-b.Notify() {
+		b.Up(a)
+	}
+
+	for i := 0; i < 4; i++ {
+		a, _, err := b.Get(canaryContext(), grpc.BalancerGetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "localhost:8081", a.Addr)
+	}
+}
+
+func TestTagRouterSetsResolverFlags(t *testing.T) {
+	r := NewResolver(nil)
+
+	TagRouter(r, TagRoute{Key: "x-canary", Value: "true", Tag: "canary"})
+
+	assert.True(t, r.Tagged)
+	assert.True(t, r.Weighted)
+}
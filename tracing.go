@@ -0,0 +1,41 @@
+package resolver
+
+import (
+	"context"
+
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer for the Connect dial spans, spans are
+// created using the global TracerProvider and are not recorded unless the
+// application has configured a provider with otel.SetTracerProvider
+const tracerName = "github.com/nicholasjackson/grpc-consul-resolver"
+
+// startDialSpan starts a span for a Connect dial to the address, the SPIFFE ID
+// of the destination is added when the address has been resolved
+func startDialSpan(ctx context.Context, addr, mode string, se catalog.ServiceEntry) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("address", addr),
+		attribute.String("mode", mode),
+	}
+
+	if se.CertURI != nil {
+		attrs = append(attrs, attribute.String("spiffe_id", se.CertURI.URI().String()))
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, "resolver.ConnectDial", trace.WithAttributes(attrs...))
+}
+
+// endDialSpan records the error and ends the span
+func endDialSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,39 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/consul/agent/connect"
+	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDialSpanContainsSPIFFEIDAndError(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	_, span := startDialSpan(context.Background(), "localhost:8443", "mesh_gateway", catalog.ServiceEntry{
+		CertURI: &connect.SpiffeIDService{Host: "abc.com", Namespace: "default", Datacenter: "dc2", Service: "web"},
+	})
+	endDialSpan(span, fmt.Errorf("boom"))
+
+	spans := sr.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "resolver.ConnectDial", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+
+	attrs := map[string]string{}
+	for _, kv := range spans[0].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsString()
+	}
+
+	assert.Equal(t, "localhost:8443", attrs["address"])
+	assert.Equal(t, "mesh_gateway", attrs["mode"])
+	assert.Equal(t, "spiffe://abc.com/ns/default/dc/dc2/svc/web", attrs["spiffe_id"])
+}
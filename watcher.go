@@ -1,11 +1,11 @@
 package resolver
 
 import (
-	"fmt"
+	"reflect"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/hashicorp/consul/api"
 	"github.com/nicholasjackson/grpc-consul-resolver/catalog"
 	"google.golang.org/grpc/naming"
 )
@@ -16,12 +16,75 @@ type ConsulWatcher struct {
 	update       time.Duration
 	service      string
 	addressCache map[string]catalog.ServiceEntry
+	draining     map[string]drainingEntry
+	added        map[string]time.Time // time each endpoint was first resolved
+	cacheMutex   sync.Mutex
 	running      uint32
+	done         chan struct{} // closed when the watcher is closed
+	lastQuery    int64         // unix time in nanoseconds of the last query
+	lastSuccess  int64         // unix time in nanoseconds of the last successful query
+	lastError    error
+	failures     int // consecutive query failures
+	errorMutex   sync.Mutex
+	subscribers  map[*Subscription]struct{}
+	limiter      *rateLimiter // shared by the watchers of a resolver, may be nil
+	current      int64        // current adaptive poll interval in nanoseconds
+	onClose      func()       // called once when the watcher is closed
+
+	// Weighted adds Metadata containing the endpoint weight to each update,
+	// endpoints whose weight has changed are replaced with a delete and an add
+	Weighted bool
+
+	// Tagged replaces endpoints whose Consul tags have changed with a delete
+	// and an add so that balancers routing by tag see the new tags
+	Tagged bool
+
+	// Timestamped adds the time each endpoint was first resolved to the
+	// Metadata of weighted updates
+	Timestamped bool
+
+	// Logger reports query failures and endpoint changes, defaults to a Logger
+	// which discards all messages
+	Logger catalog.Logger
+
+	// Jitter randomizes the interval between queries by up to the given
+	// fraction of the interval, i.e. 0.1 waits between 90% and 110% of the
+	// interval
+	Jitter float64
+
+	// Adaptive polls at MinInterval after the endpoints change or a query
+	// fails and doubles the interval while the endpoints are unchanged until
+	// MaxInterval is reached, failed queries are retried rather than returned
+	// from Next
+	Adaptive bool
+	// MinInterval is the interval after a change, defaults to 1 second
+	MinInterval time.Duration
+	// MaxInterval is the interval while the endpoints are unchanged, defaults
+	// to the watch interval
+	MaxInterval time.Duration
+
+	// DrainPeriod keeps endpoints which have been removed from Consul or
+	// entered maintenance for the given period, draining endpoints receive no
+	// new requests from the WeightedRoundRobin balancer and are deleted once
+	// the period expires or their requests have completed. When 0 or when
+	// Weighted is not set endpoints are deleted immediately
+	DrainPeriod time.Duration
 }
 
 // NewConsulWatcher creates and returns a ConsulWatcher with the given parameters
 func NewConsulWatcher(service string, q catalog.Query, watchInterval time.Duration) *ConsulWatcher {
-	return &ConsulWatcher{q, watchInterval, service, make(map[string]catalog.ServiceEntry), 1}
+	return &ConsulWatcher{
+		query:        q,
+		update:       watchInterval,
+		service:      service,
+		addressCache: make(map[string]catalog.ServiceEntry),
+		draining:     make(map[string]drainingEntry),
+		added:        make(map[string]time.Time),
+		subscribers:  make(map[*Subscription]struct{}),
+		running:      1,
+		done:         make(chan struct{}),
+		Logger:       catalog.NewNopLogger(),
+	}
 }
 
 // Next blocks until an update or error happens. It may return one or more
@@ -29,18 +92,38 @@ func NewConsulWatcher(service string, q catalog.Query, watchInterval time.Durati
 // return an error if and only if Watcher cannot recover.
 func (c *ConsulWatcher) Next() ([]*naming.Update, error) {
 	for atomic.LoadUint32(&c.running) == 1 {
+		if !c.waitForLimiter() {
+			break
+		}
+
+		start := time.Now()
 		se, err := c.query.Execute(c.service, nil)
+		c.emitQueryMetrics(start, err)
+		c.recordResult(start, err)
+
 		if err != nil {
-			return nil, err
+			catalog.LoggerOrNop(c.Logger).Error("query failed", "target", c.service, "error", err)
+			if !c.Adaptive {
+				return nil, err
+			}
+
+			// retry after the minimum interval rather than returning the
+			// error as gRPC stops watching the target when Next fails
+			c.resetInterval()
+			time.Sleep(c.nextInterval())
+			continue
 		}
 
+		atomic.StoreInt64(&c.lastSuccess, time.Now().UnixNano())
+
 		up, err := c.buildUpdate(se)
 
 		if len(up) > 0 {
+			c.resetInterval()
 			return up, nil
 		}
 
-		time.Sleep(c.update)
+		time.Sleep(c.drainInterval(c.nextInterval(), time.Now()))
 	}
 
 	return nil, nil
@@ -48,43 +131,261 @@ func (c *ConsulWatcher) Next() ([]*naming.Update, error) {
 
 // Close closes the Watcher.
 func (c *ConsulWatcher) Close() {
-	atomic.StoreUint32(&c.running, 0)
+	if !atomic.CompareAndSwapUint32(&c.running, 1, 0) {
+		return
+	}
+
+	close(c.done)
+
+	if c.onClose != nil {
+		c.onClose()
+	}
 }
 
 func (c *ConsulWatcher) buildUpdate(ses []catalog.ServiceEntry) ([]*naming.Update, error) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
 	nu := make([]*naming.Update, 0)
+	now := time.Now()
 
 	// check additions
 	for _, se := range ses {
 		addr := se.Addr
 		// does this address already exist in the cache?
-		if _, ok := c.addressCache[addr]; ok != true {
-			c.addressCache[addr] = se
+		old, ok := c.addressCache[addr]
+		if ok != true {
+			// an endpoint which returns while draining is added again so that
+			// the balancer stops draining it
+			delete(c.draining, addr)
+			c.added[addr] = now
 
-			n := &naming.Update{
-				Op:   naming.Add,
-				Addr: addr,
-			}
+			catalog.LoggerOrNop(c.Logger).Info("endpoint added", "target", c.service, "address", addr)
+			c.publish(Event{Type: EventAdd, Target: c.service, Entry: se})
+			nu = append(nu, c.newUpdate(naming.Add, se))
+		} else if !reflect.DeepEqual(old, se) {
+			c.publish(Event{Type: EventModify, Target: c.service, Entry: se, Previous: old})
 
-			nu = append(nu, n)
+			if (c.Weighted && old.Weight != se.Weight) || (c.Tagged && !sameTags(old.Tags, se.Tags)) {
+				// the balancer identifies an address by its address and metadata,
+				// replace the endpoint so that the new weight or tags are applied
+				catalog.LoggerOrNop(c.Logger).Info("endpoint modified", "target", c.service, "address", addr, "weight", se.Weight, "tags", se.Tags)
+				nu = append(nu, c.newUpdate(naming.Delete, old), c.newUpdate(naming.Add, se))
+			}
 		}
+
+		c.addressCache[addr] = se
 	}
 
-	// check deletions
-	for k := range c.addressCache {
+	// check deletions, instances in maintenance are critical and are not
+	// returned by the query so they are drained in the same way
+	for k, se := range c.addressCache {
 		if !serviceEntryContains(k, ses) {
-			n := &naming.Update{
-				Op:   naming.Delete,
-				Addr: k,
+			c.publish(Event{Type: EventRemove, Target: c.service, Entry: se})
+
+			if c.DrainPeriod > 0 && c.Weighted {
+				nu = append(nu, c.startDrain(se, now))
+				continue
 			}
 
-			nu = append(nu, n)
+			catalog.LoggerOrNop(c.Logger).Info("endpoint removed", "target", c.service, "address", k)
+			nu = append(nu, c.newUpdate(naming.Delete, se))
+			delete(c.addressCache, k)
+			delete(c.added, k)
 		}
 	}
 
+	nu = append(nu, c.expireDrained(now)...)
+
+	adds, drains := 0, 0
+	for _, u := range nu {
+		if m, ok := u.Metadata.(Metadata); ok && m.Draining {
+			drains++
+		} else if u.Op == naming.Add {
+			adds++
+		}
+	}
+	c.emitUpdateMetrics(adds, len(nu)-adds-drains, drains)
+
 	return nu, nil
 }
 
+// waitForLimiter blocks until the rate limiter allows the query, the first
+// query for a target is an initial query whether or not it succeeds so that
+// a failing target does not take priority over refreshes. false is returned
+// when the watcher is closed while waiting
+func (c *ConsulWatcher) waitForLimiter() bool {
+	if c.limiter == nil {
+		return true
+	}
+
+	initial := atomic.LoadInt64(&c.lastQuery) == 0
+	wait, ok := c.limiter.wait(initial, c.done)
+	if ok {
+		c.emitThrottleMetrics(initial, wait)
+	}
+
+	return ok
+}
+
+// recordResult records the time and error of the query for debugging
+func (c *ConsulWatcher) recordResult(start time.Time, err error) {
+	atomic.StoreInt64(&c.lastQuery, start.UnixNano())
+
+	c.errorMutex.Lock()
+	defer c.errorMutex.Unlock()
+
+	c.lastError = err
+	if err != nil {
+		c.failures++
+		return
+	}
+
+	c.failures = 0
+}
+
+// queryError returns the number of consecutive failures and the error of the
+// last query
+func (c *ConsulWatcher) queryError() (int, error) {
+	c.errorMutex.Lock()
+	defer c.errorMutex.Unlock()
+
+	return c.failures, c.lastError
+}
+
+// subscribe adds the subscription to the watcher, the endpoints already in
+// the cache are sent to the subscription as EventAdd
+func (c *ConsulWatcher) subscribe(s *Subscription) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	c.subscribers[s] = struct{}{}
+
+	for _, se := range c.addressCache {
+		s.publish(Event{Type: EventAdd, Target: c.service, Entry: se})
+	}
+}
+
+// adopt adds the subscription to the watcher without sending the endpoints
+// in the cache, this is used when a subscription moves between watchers
+func (c *ConsulWatcher) adopt(s *Subscription) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	c.subscribers[s] = struct{}{}
+}
+
+// takeSubscribers removes and returns the subscriptions and the endpoints in
+// the cache
+func (c *ConsulWatcher) takeSubscribers() ([]*Subscription, []catalog.ServiceEntry) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	subs := make([]*Subscription, 0, len(c.subscribers))
+	for s := range c.subscribers {
+		subs = append(subs, s)
+		delete(c.subscribers, s)
+	}
+
+	ses := make([]catalog.ServiceEntry, 0, len(c.addressCache))
+	for _, se := range c.addressCache {
+		ses = append(ses, se)
+	}
+
+	return subs, ses
+}
+
+// seed adds the endpoints to the cache of a watcher which has not been
+// started, only changes to the endpoints are then published
+func (c *ConsulWatcher) seed(ses []catalog.ServiceEntry) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	for _, se := range ses {
+		c.addressCache[se.Addr] = se
+	}
+}
+
+// unsubscribe removes the subscription and returns the number of remaining
+// subscriptions
+func (c *ConsulWatcher) unsubscribe(s *Subscription) int {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	delete(c.subscribers, s)
+
+	return len(c.subscribers)
+}
+
+// publish sends the event to all subscriptions, the caller must hold the
+// cache mutex
+func (c *ConsulWatcher) publish(e Event) {
+	for s := range c.subscribers {
+		s.publish(e)
+	}
+}
+
+// serviceEntry returns the cached ServiceEntry for the address, draining
+// endpoints are returned so that their connections can be re-established
+func (c *ConsulWatcher) serviceEntry(address string) (catalog.ServiceEntry, bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	if se, ok := c.addressCache[address]; ok {
+		return se, true
+	}
+
+	d, ok := c.draining[address]
+	return d.entry, ok
+}
+
+// endpoints returns a copy of the cached endpoints
+func (c *ConsulWatcher) endpoints() []catalog.ServiceEntry {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	ses := make([]catalog.ServiceEntry, 0, len(c.addressCache))
+	for _, se := range c.addressCache {
+		ses = append(ses, se)
+	}
+
+	return ses
+}
+
+// newUpdate returns the update for the endpoint, the caller must hold the
+// cache mutex
+func (c *ConsulWatcher) newUpdate(op naming.Operation, se catalog.ServiceEntry) *naming.Update {
+	n := &naming.Update{
+		Op:   op,
+		Addr: se.Addr,
+	}
+
+	if c.Weighted {
+		m := Metadata{Weight: se.Weight}
+		if c.Timestamped {
+			m.Added = c.added[se.Addr]
+		}
+
+		n.Metadata = m
+	}
+
+	return n
+}
+
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
 func serviceEntryContains(s string, in []catalog.ServiceEntry) bool {
 	for _, i := range in {
 		if i.Addr == s {
@@ -94,11 +395,3 @@ func serviceEntryContains(s string, in []catalog.ServiceEntry) bool {
 
 	return false
 }
-
-func buildAddress(se *api.ServiceEntry) string {
-	if se.Service.Address != "" {
-		return fmt.Sprintf("%s:%d", se.Service.Address, se.Service.Port)
-	}
-
-	return fmt.Sprintf("%s:%d", se.Node.Address, se.Service.Port)
-}
@@ -46,6 +46,18 @@ func TestNextReturnsErrorWhenConsulError(t *testing.T) {
 	assert.NotNil(t, err, "Should have returned an error")
 }
 
+func TestNextLogsQueryFailure(t *testing.T) {
+	w := setupWatcher(t)
+	logger := catalog.NewMockLogger()
+	w.Logger = logger
+	queryMock.ExpectedCalls = make([]*mock.Call, 0)
+	queryMock.On("Execute", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("Boom"))
+
+	w.Next()
+
+	logger.AssertCalled(t, "Error", "query failed", []interface{}{"target", "test", "error", fmt.Errorf("Boom")})
+}
+
 func TestNextReturnsInitialUpdatesFromConsul(t *testing.T) {
 	w := setupWatcher(t)
 
@@ -89,6 +101,80 @@ func TestNextReturnsUpdatesContainingDeletedItemsFromConsul(t *testing.T) {
 	assert.Equal(t, naming.Delete, nu[0].Op)
 }
 
+func TestNextLogsAddedAndRemovedItems(t *testing.T) {
+	w := setupWatcher(t)
+	logger := catalog.NewMockLogger()
+	w.Logger = logger
+	w.Next()
+
+	ses[0] = catalog.ServiceEntry{Addr: "localhost:8081"}
+	w.Next()
+
+	logger.AssertCalled(t, "Info", "endpoint added", []interface{}{"target", "test", "address", "localhost:8080"})
+	logger.AssertCalled(t, "Info", "endpoint added", []interface{}{"target", "test", "address", "localhost:8081"})
+	logger.AssertCalled(t, "Info", "endpoint removed", []interface{}{"target", "test", "address", "localhost:8080"})
+}
+
+func TestNextReturnsDeletedItemsOnlyOnce(t *testing.T) {
+	w := setupWatcher(t)
+	w.Next()
+	ses = make([]catalog.ServiceEntry, 0)
+	w.Next()
+	ses = append(ses, catalog.ServiceEntry{
+		Addr: "localhost:8090",
+	})
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 1, "Should have returned 1 updates")
+	assert.Equal(t, "localhost:8090", nu[0].Addr)
+	assert.Equal(t, naming.Add, nu[0].Op)
+}
+
+func TestNextReturnsWeightMetadataWhenWeighted(t *testing.T) {
+	w := setupWatcher(t)
+	w.Weighted = true
+	ses[0].Weight = 5
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 1, "Should have returned 1 update")
+	assert.Equal(t, Metadata{Weight: 5}, nu[0].Metadata)
+}
+
+func TestNextReplacesItemsWhenWeightChanges(t *testing.T) {
+	w := setupWatcher(t)
+	w.Weighted = true
+	ses[0].Weight = 5
+	w.Next()
+	ses[0].Weight = 1
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 2, "Should have returned 2 updates")
+	assert.Equal(t, naming.Delete, nu[0].Op)
+	assert.Equal(t, Metadata{Weight: 5}, nu[0].Metadata)
+	assert.Equal(t, naming.Add, nu[1].Op)
+	assert.Equal(t, Metadata{Weight: 1}, nu[1].Metadata)
+}
+
+func TestNextReplacesItemsWhenTagsChangeAndTagged(t *testing.T) {
+	w := setupWatcher(t)
+	w.Tagged = true
+	w.Next()
+	ses[0].Tags = []string{"canary"}
+
+	nu, err := w.Next()
+
+	assert.NoError(t, err)
+	assert.Len(t, nu, 2, "Should have returned 2 updates")
+	assert.Equal(t, naming.Delete, nu[0].Op)
+	assert.Equal(t, naming.Add, nu[1].Op)
+}
+
 func TestNextBlocksWhenNoChangesFromConsul(t *testing.T) {
 	w := setupWatcher(t)
 	w.Next()
@@ -96,7 +182,7 @@ func TestNextBlocksWhenNoChangesFromConsul(t *testing.T) {
 	timeOut := make(chan bool)
 
 	// test after 3 iterations
-	time.AfterFunc(31*time.Millisecond, func() {
+	time.AfterFunc(25*time.Millisecond, func() {
 		timeOut <- true
 	})
 